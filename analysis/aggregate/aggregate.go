@@ -3,6 +3,7 @@ package aggregate
 import (
 	"fmt"
 	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/box/memsniff/protocol/model"
 	"math"
 	"strconv"
 )
@@ -15,6 +16,17 @@ type Aggregator interface {
 	Result() int64
 	// Reset returns the aggregator to its initial state.
 	Reset()
+	// Merge adds all data points recorded by other, which must be an
+	// Aggregator of the same type.
+	Merge(other Aggregator)
+}
+
+// EventAggregator is an Aggregator that summarizes the events themselves
+// rather than the value of a field, such as whether they were hits or misses.
+type EventAggregator interface {
+	Aggregator
+	// AddEvent records a single event.
+	AddEvent(e model.Event)
 }
 
 // maxMicros is the longest time interval we are interested in tracking by default, just over a minute assuming
@@ -54,6 +66,12 @@ func (m *Max) Reset() {
 	m.max = 0
 }
 
+func (m *Max) Merge(other Aggregator) {
+	if o := other.(*Max); o.seenFirst {
+		m.Add(o.max)
+	}
+}
+
 // Min retains the minimum value in the aggregated data.
 type Min struct {
 	min       int64
@@ -80,6 +98,12 @@ func (m *Min) Reset() {
 	m.min = 0
 }
 
+func (m *Min) Merge(other Aggregator) {
+	if o := other.(*Min); o.seenFirst {
+		m.Add(o.min)
+	}
+}
+
 // Sum returns the sum of the aggregated data.
 type Sum struct {
 	sum int64
@@ -97,6 +121,10 @@ func (s *Sum) Reset() {
 	s.sum = 0
 }
 
+func (s *Sum) Merge(other Aggregator) {
+	s.sum += other.(*Sum).sum
+}
+
 // Mean returns the arithmetic mean of the aggregated data.
 type Mean struct {
 	sum   int64
@@ -120,6 +148,120 @@ func (m *Mean) Reset() {
 	m.count = 0
 }
 
+func (m *Mean) Merge(other Aggregator) {
+	o := other.(*Mean)
+	m.sum += o.sum
+	m.count += o.count
+}
+
+// Count returns the number of data points aggregated.
+type Count struct {
+	count int64
+}
+
+func (c *Count) Add(n int64) {
+	c.count++
+}
+
+func (c *Count) Result() int64 {
+	return c.count
+}
+
+func (c *Count) Reset() {
+	c.count = 0
+}
+
+func (c *Count) Merge(other Aggregator) {
+	c.count += other.(*Count).count
+}
+
+// StdDev returns the population standard deviation of the aggregated data,
+// rounded to the nearest integer.
+type StdDev struct {
+	count int64
+	mean  float64
+	// m2 is the sum of squared differences from the mean.
+	m2 float64
+}
+
+func (s *StdDev) Add(n int64) {
+	// Welford's online algorithm
+	s.count++
+	delta := float64(n) - s.mean
+	s.mean += delta / float64(s.count)
+	s.m2 += delta * (float64(n) - s.mean)
+}
+
+func (s *StdDev) Result() int64 {
+	if s.count == 0 {
+		return 0
+	}
+	return int64(math.Round(math.Sqrt(s.m2 / float64(s.count))))
+}
+
+func (s *StdDev) Reset() {
+	s.count = 0
+	s.mean = 0
+	s.m2 = 0
+}
+
+func (s *StdDev) Merge(other Aggregator) {
+	// Chan et al.'s parallel algorithm
+	o := other.(*StdDev)
+	if o.count == 0 {
+		return
+	}
+	count := s.count + o.count
+	delta := o.mean - s.mean
+	s.mean += delta * float64(o.count) / float64(count)
+	s.m2 += o.m2 + delta*delta*float64(s.count)*float64(o.count)/float64(count)
+	s.count = count
+}
+
+// HitMiss counts retrievals that returned data and those that did not, and
+// reports one of the counts or the hit ratio.
+type HitMiss struct {
+	hits   int64
+	misses int64
+	result func(hits, misses int64) int64
+}
+
+// Add does nothing, since only the type of each event is of interest.
+func (h *HitMiss) Add(n int64) {}
+
+func (h *HitMiss) AddEvent(e model.Event) {
+	if e.Type.IsHit() {
+		h.hits++
+	} else if e.Type.IsMiss() {
+		h.misses++
+	}
+}
+
+func (h *HitMiss) Result() int64 {
+	return h.result(h.hits, h.misses)
+}
+
+func (h *HitMiss) Reset() {
+	h.hits = 0
+	h.misses = 0
+}
+
+func (h *HitMiss) Merge(other Aggregator) {
+	o := other.(*HitMiss)
+	h.hits += o.hits
+	h.misses += o.misses
+}
+
+// hitRatio returns the percentage of retrievals that were hits.  Keys that
+// were never retrieved have nothing to miss, and report 100 so that they sort
+// after keys that missed.
+func hitRatio(hits, misses int64) int64 {
+	if hits+misses == 0 {
+		return 100
+	}
+	return int64(math.Round(float64(hits) * 100 / float64(hits+misses)))
+}
+
 // Percentile returns the nth percentile sample from the aggregated data.
 type Percentile struct {
 	q float64
@@ -154,10 +296,31 @@ func (p *Percentile) Reset() {
 	p.h.Reset()
 }
 
+func (p *Percentile) Merge(other Aggregator) {
+	p.h.Merge(other.(*Percentile).h)
+}
+
+// ByteSize returns the approximate memory used by this aggregator.
+func (p *Percentile) ByteSize() int {
+	return p.h.ByteSize()
+}
+
+// IsFieldlessAgg returns true if desc is an aggregator that can be used
+// without a field, such as count(), counting each event once.
+func IsFieldlessAgg(desc string) bool {
+	switch desc {
+	case "count", "rate", "erate", "hits", "misses", "hitratio":
+		return true
+	default:
+		return false
+	}
+}
+
 // IsValidAgg returns true if desc is a valid descriptor for an aggregator type.
 func IsValidAgg(desc string) bool {
 	switch desc {
-	case "max", "min", "mean", "avg", "sum":
+	case "max", "min", "mean", "avg", "sum", "count", "rate", "erate", "stddev",
+		"hits", "misses", "hitratio", "distinct":
 		return true
 
 	default:
@@ -200,6 +363,33 @@ func NewFactoryFromDescriptor(desc string) (AggregatorFactory, error) {
 	case "sum":
 		return func() Aggregator { return &Sum{} }, nil
 
+	case "count":
+		return func() Aggregator { return &Count{} }, nil
+
+	case "rate", "erate":
+		// a total, converted to a rate per second by KeyAggregatorFactory.Normalize,
+		// or for erate decayed over time when reporting rolling windows
+		return func() Aggregator { return &Sum{} }, nil
+
+	case "stddev":
+		return func() Aggregator { return &StdDev{} }, nil
+
+	case "hits":
+		return func() Aggregator {
+			return &HitMiss{result: func(hits, misses int64) int64 { return hits }}
+		}, nil
+
+	case "misses":
+		return func() Aggregator {
+			return &HitMiss{result: func(hits, misses int64) int64 { return misses }}
+		}, nil
+
+	case "hitratio":
+		return func() Aggregator { return &HitMiss{result: hitRatio} }, nil
+
+	case "distinct":
+		return func() Aggregator { return &HyperLogLog{} }, nil
+
 	default:
 		if len(desc) >= 3 && desc[0] == 'p' {
 			return percentileFactoryFromDescriptor(desc)
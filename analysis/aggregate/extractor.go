@@ -3,15 +3,17 @@ package aggregate
 import (
 	"bytes"
 	"github.com/box/memsniff/protocol/model"
+	"net"
 	"regexp"
 	"strconv"
+	"time"
 )
 
 var aggregatorRegex *regexp.Regexp
 
 func init() {
 	var err error
-	aggregatorRegex, err = regexp.Compile(`^([a-z0-9]+)\(([a-z]+)\)$`)
+	aggregatorRegex, err = regexp.Compile(`^([a-z0-9]+)\(([a-z]*)\)$`)
 	if err != nil {
 		panic(err)
 	}
@@ -23,38 +25,93 @@ func fieldIDFromDescriptor(desc string) (model.EventFieldMask, error) {
 		return model.FieldKey, nil
 	case "size":
 		return model.FieldSize, nil
+	case "cmd", "op":
+		return model.FieldCmd, nil
+	case "latency":
+		return model.FieldLatency, nil
+	case "client":
+		return model.FieldClient, nil
+	case "clientport":
+		return model.FieldClientPort, nil
+	case "server":
+		return model.FieldServer, nil
+	case "db":
+		return model.FieldDB, nil
+	case "user":
+		return model.FieldUser, nil
+	case "script":
+		return model.FieldScript, nil
+	case "slot":
+		return model.FieldSlot, nil
+	case "target":
+		return model.FieldTarget, nil
+	case "error":
+		return model.FieldError, nil
+	case "route":
+		return model.FieldRoute, nil
+	case "keypattern":
+		return model.FieldKeyPattern, nil
 	default:
 		return 0, BadDescriptorError(desc)
 	}
 }
 
-func fieldsAsStrings(e model.Event, mask model.EventFieldMask) []string {
-	res := make([]string, 0, 2)
-	for id := model.EventFieldMask(1); id < model.FieldEndOfFields; id <<= 1 {
-		if mask&id == id {
-			res = append(res, fieldAsString(e, id))
-		}
+// KeyPatternFunc derives the keypattern field from the key of an event.
+type KeyPatternFunc func(key string) string
+
+func fieldsAsStrings(e model.Event, ids []model.EventFieldMask, keyPattern KeyPatternFunc) []string {
+	res := make([]string, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, fieldAsString(e, id, keyPattern))
 	}
 	return res
 }
 
-func fieldsAsString(e model.Event, mask model.EventFieldMask) string {
+func fieldsAsString(e model.Event, ids []model.EventFieldMask, keyPattern KeyPatternFunc) string {
 	var buf bytes.Buffer
-	for id := model.EventFieldMask(1); id < model.FieldEndOfFields; id <<= 1 {
-		if mask&id == id {
-			buf.WriteString(fieldAsString(e, id))
-			buf.WriteByte(0)
-		}
+	for _, id := range ids {
+		buf.WriteString(fieldAsString(e, id, keyPattern))
+		buf.WriteByte(0)
 	}
 	return buf.String()
 }
 
-func fieldAsString(e model.Event, id model.EventFieldMask) string {
+func fieldAsString(e model.Event, id model.EventFieldMask, keyPattern KeyPatternFunc) string {
 	switch id {
 	case model.FieldKey:
 		return e.Key
 	case model.FieldSize:
 		return strconv.Itoa(e.Size)
+	case model.FieldCmd:
+		// errors are identified by the error field, show what failed
+		return e.CommandName()
+	case model.FieldLatency:
+		return strconv.FormatInt(latencyMicros(e), 10)
+	case model.FieldClient:
+		return e.ClientAddr
+	case model.FieldClientPort:
+		return strconv.Itoa(e.ClientPort)
+	case model.FieldServer:
+		return net.JoinHostPort(e.ServerAddr, strconv.Itoa(e.ServerPort))
+	case model.FieldDB:
+		return strconv.Itoa(e.DB)
+	case model.FieldUser:
+		return e.User
+	case model.FieldScript:
+		return e.Script
+	case model.FieldSlot:
+		return strconv.Itoa(e.Slot)
+	case model.FieldTarget:
+		return e.Target
+	case model.FieldError:
+		return e.Error
+	case model.FieldRoute:
+		return e.Route
+	case model.FieldKeyPattern:
+		if keyPattern == nil {
+			return e.Key
+		}
+		return keyPattern(e.Key)
 	default:
 		panic("bad fieldId")
 	}
@@ -62,9 +119,20 @@ func fieldAsString(e model.Event, id model.EventFieldMask) string {
 
 func fieldAsInt64(e model.Event, id model.EventFieldMask) int64 {
 	switch id {
+	case model.FieldNone:
+		// field-less aggregates count each event once
+		return 1
 	case model.FieldSize:
 		return int64(e.Size)
+	case model.FieldLatency:
+		return latencyMicros(e)
 	default:
 		panic("bad fieldId")
 	}
 }
+
+// latencyMicros returns the latency of e in microseconds, the unit used for
+// all time intervals in aggregates.
+func latencyMicros(e model.Event) int64 {
+	return int64(e.Latency / time.Microsecond)
+}
@@ -2,7 +2,9 @@ package aggregate
 
 import (
 	"github.com/box/memsniff/protocol/model"
+	"math"
 	"strings"
+	"time"
 )
 
 // KeyAggregator tracks data across all requested event fields for a single key.
@@ -16,15 +18,36 @@ type KeyAggregator struct {
 	aggFieldIDs []model.EventFieldMask
 	// aggs is the actual aggregators, in the same order as the descriptor string.
 	aggs []Aggregator
+	// hashed is true for each aggregator that takes a hash of its field
+	// rather than its value.
+	hashed []bool
+	// keyPattern derives the keypattern field, as set on the factory.
+	keyPattern KeyPatternFunc
 }
 
 // Add updates all aggregators tracked for this key according to the provided event.
 func (ka KeyAggregator) Add(e model.Event) {
 	for i := range ka.aggs {
+		if ea, ok := ka.aggs[i].(EventAggregator); ok {
+			ea.AddEvent(e)
+			continue
+		}
+		if ka.hashed[i] {
+			ka.aggs[i].Add(HashString(fieldAsString(e, ka.aggFieldIDs[i], ka.keyPattern)))
+			continue
+		}
 		ka.aggs[i].Add(fieldAsInt64(e, ka.aggFieldIDs[i]))
 	}
 }
 
+// Merge adds all events recorded by other, which must have been created by
+// the same KeyAggregatorFactory.
+func (ka KeyAggregator) Merge(other KeyAggregator) {
+	for i := range ka.aggs {
+		ka.aggs[i].Merge(other.aggs[i])
+	}
+}
+
 // Result returns the aggregation results for this key, in order of their appearance
 // in the descriptor used to create the KeyAggregatorFactory.
 func (ka KeyAggregator) Result() []int64 {
@@ -44,8 +67,10 @@ func (ka *KeyAggregator) Reset() {
 }
 
 // NewKeyAggregatorFactory creates a KeyAggregatorFactory.  The descriptor should be a
-// comma-separated list of field names (key, size, etc.) and aggregate descriptions
-// (sum(size), p99(latency), etc.).
+// comma-separated list of field names (key, size, cmd, etc.) and aggregate descriptions
+// (sum(size), p99(latency), etc.).  Aggregates that need no field may be written
+// without one, as in count or rate().  Only distinct may aggregate fields that
+// are not integers, as in distinct(key).
 func NewKeyAggregatorFactory(desc string) (KeyAggregatorFactory, error) {
 	fieldDescs := strings.Split(desc, ",")
 
@@ -60,10 +85,11 @@ func NewKeyAggregatorFactory(desc string) (KeyAggregatorFactory, error) {
 		if aggDesc == "" {
 			// simple field
 			kaf.KeyFields = append(kaf.KeyFields, field)
-			kaf.keyFieldMask |= fieldID
+			kaf.keyFieldIDs = append(kaf.keyFieldIDs, fieldID)
 		} else {
-			// can aggregate integer fields only
-			if fieldID&model.IntFields == 0 {
+			// can aggregate integer fields only, except to count distinct values
+			hashed := aggDesc == "distinct"
+			if fieldID != model.FieldNone && fieldID&model.IntFields == 0 && !hashed {
 				return KeyAggregatorFactory{}, BadDescriptorError(field)
 			}
 			aggFactory, err := NewFactoryFromDescriptor(aggDesc)
@@ -74,6 +100,9 @@ func NewKeyAggregatorFactory(desc string) (KeyAggregatorFactory, error) {
 			kaf.AggFields = append(kaf.AggFields, field)
 			kaf.aggFieldIDs = append(kaf.aggFieldIDs, fieldID)
 			kaf.aggFactories = append(kaf.aggFactories, aggFactory)
+			kaf.rates = append(kaf.rates, aggDesc == "rate" || aggDesc == "erate")
+			kaf.decayed = append(kaf.decayed, aggDesc == "erate")
+			kaf.hashed = append(kaf.hashed, hashed)
 		}
 	}
 
@@ -91,6 +120,11 @@ func parseField(field string) (fieldID model.EventFieldMask, aggDesc string, err
 		return
 	}
 
+	// field-less aggregate without parentheses, as in "count"
+	if IsFieldlessAgg(field) {
+		return model.FieldNone, field, nil
+	}
+
 	// try to parse as aggregate descriptor
 	matches := aggregatorRegex.FindStringSubmatch(field)
 	if matches == nil {
@@ -101,6 +135,12 @@ func parseField(field string) (fieldID model.EventFieldMask, aggDesc string, err
 	}
 
 	aggDesc = matches[1]
+	if matches[2] == "" {
+		if !IsFieldlessAgg(aggDesc) {
+			return 0, "", BadDescriptorError(field)
+		}
+		return model.FieldNone, aggDesc, nil
+	}
 	fieldID, err = fieldIDFromDescriptor(matches[2])
 	if err != nil {
 		return 0, "", err
@@ -114,20 +154,31 @@ func parseField(field string) (fieldID model.EventFieldMask, aggDesc string, err
 type KeyAggregatorFactory struct {
 	// keyFields is the names of the fields to use as keys: ["key", "size"]
 	KeyFields []string
-	// keyFieldMask is the logical-OR of the field IDs to use as keys.
-	keyFieldMask model.EventFieldMask
+	// keyFieldIDs is the fieldIds of the fields to use as keys, in order of display.
+	keyFieldIDs []model.EventFieldMask
 	// AggFields is the names of the fields to aggregate over, in order of display.
 	AggFields []string
 	// aggFieldIDs is the fieldIds of the fields to aggregate over, in order of display.
 	aggFieldIDs []model.EventFieldMask
 	// aggFactories are AggregatorFactories to create the correct type of aggregator for the matching aggField.
 	aggFactories []AggregatorFactory
+	// rates is true for each aggField whose result is normalized to a rate per second.
+	rates []bool
+	// hashed is true for each aggField that is aggregated by the hash of its value.
+	hashed []bool
+	// decayed is true for each aggField that is an exponentially decayed rate.
+	decayed []bool
+	// keyPattern derives the keypattern field from keys, or is nil to use
+	// keys unchanged.
+	keyPattern KeyPatternFunc
 }
 
 // New creates a new KeyAggregator configured to perform aggregation based on the descriptor
 // used to create this KeyAggregatorFactory.
 func (f KeyAggregatorFactory) New() (ka KeyAggregator) {
 	ka.aggFieldIDs = f.aggFieldIDs
+	ka.hashed = f.hashed
+	ka.keyPattern = f.keyPattern
 	ka.aggs = make([]Aggregator, len(f.aggFactories))
 	for i := range f.aggFactories {
 		ka.aggs[i] = f.aggFactories[i]()
@@ -135,13 +186,86 @@ func (f KeyAggregatorFactory) New() (ka KeyAggregator) {
 	return
 }
 
+const (
+	// entryOverhead is the approximate memory used to track a key, aside
+	// from its fields and aggregators.
+	entryOverhead = 128
+	// keyFieldSize is the assumed memory used by each key field.
+	keyFieldSize = 64
+	// aggOverhead is the approximate memory used by a simple aggregator.
+	aggOverhead = 32
+)
+
+// EntrySize returns the approximate memory needed to track one key.
+func (f KeyAggregatorFactory) EntrySize() int {
+	size := entryOverhead + 2*keyFieldSize*len(f.keyFieldIDs)
+	for _, agg := range f.New().aggs {
+		if s, ok := agg.(interface{ ByteSize() int }); ok {
+			size += s.ByteSize()
+		} else {
+			size += aggOverhead
+		}
+	}
+	return size
+}
+
+// Normalize converts the results of rate aggregates in values, as returned by
+// KeyAggregator.Result, from totals to rates per second over interval.
+func (f KeyAggregatorFactory) Normalize(values []int64, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for i, isRate := range f.rates {
+		if isRate {
+			values[i] = int64(math.Round(float64(values[i]) * float64(time.Second) / float64(interval)))
+		}
+	}
+}
+
+// IsDecayed returns true if the ith aggregate is a rate that should be
+// exponentially decayed over time, rather than averaged over an interval.
+func (f KeyAggregatorFactory) IsDecayed(i int) bool {
+	return f.decayed[i]
+}
+
+// SetKeyPattern sets the function used to derive the keypattern field from
+// the key of each event.  It must be called before any KeyAggregators are
+// created.
+func (f *KeyAggregatorFactory) SetKeyPattern(keyPattern KeyPatternFunc) {
+	f.keyPattern = keyPattern
+}
+
+// HasKeyField returns true if id is one of the key fields.
+func (f KeyAggregatorFactory) HasKeyField(id model.EventFieldMask) bool {
+	for _, k := range f.keyFieldIDs {
+		if k == id {
+			return true
+		}
+	}
+	return false
+}
+
+// UsesField returns true if id is one of the key fields or a field aggregated
+// over.
+func (f KeyAggregatorFactory) UsesField(id model.EventFieldMask) bool {
+	if f.HasKeyField(id) {
+		return true
+	}
+	for _, a := range f.aggFieldIDs {
+		if a == id {
+			return true
+		}
+	}
+	return false
+}
+
 // FlatKey returns a string key based on the flattened key fields of an event,
 // suitable for use in a map.
 func (f KeyAggregatorFactory) FlatKey(e model.Event) string {
-	return fieldsAsString(e, f.keyFieldMask)
+	return fieldsAsString(e, f.keyFieldIDs, f.keyPattern)
 }
 
 // Key returns a list of strings used together as the composite key for an event.
 func (f KeyAggregatorFactory) Key(e model.Event) []string {
-	return fieldsAsStrings(e, f.keyFieldMask)
+	return fieldsAsStrings(e, f.keyFieldIDs, f.keyPattern)
 }
@@ -2,7 +2,9 @@ package aggregate
 
 import (
 	"github.com/box/memsniff/protocol/model"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestKeyString(t *testing.T) {
@@ -18,6 +20,107 @@ func TestKeyString(t *testing.T) {
 	}
 }
 
+func TestCmdField(t *testing.T) {
+	kaf, err := NewKeyAggregatorFactory("cmd,key,sum(size)")
+	if err != nil {
+		t.Error(err)
+	}
+	key := kaf.Key(model.Event{Type: model.EventDelete, Key: "key1"})
+	if len(key) != 2 || key[0] != "delete" || key[1] != "key1" {
+		t.Error(key)
+	}
+
+	if kaf.FlatKey(model.Event{Type: model.EventGetHit, Key: "key1"}) !=
+		kaf.FlatKey(model.Event{Type: model.EventGetMiss, Key: "key1"}) {
+		t.Error("hits and misses should share a command")
+	}
+}
+
+func TestEndpointFields(t *testing.T) {
+	kaf, err := NewKeyAggregatorFactory("client,clientport,server,sum(size)")
+	if err != nil {
+		t.Error(err)
+	}
+	key := kaf.Key(model.Event{
+		ClientAddr: "10.0.0.1",
+		ClientPort: 53124,
+		ServerAddr: "10.0.0.2",
+		ServerPort: 11211,
+	})
+	if len(key) != 3 || key[0] != "10.0.0.1" || key[1] != "53124" || key[2] != "10.0.0.2:11211" {
+		t.Error(key)
+	}
+}
+
+func TestSessionFields(t *testing.T) {
+	kaf, err := NewKeyAggregatorFactory("db,user,key,sum(size)")
+	if err != nil {
+		t.Error(err)
+	}
+	key := kaf.Key(model.Event{Key: "key1", DB: 3, User: "alice"})
+	if len(key) != 3 || key[0] != "3" || key[1] != "alice" || key[2] != "key1" {
+		t.Error(key)
+	}
+}
+
+func TestSlotField(t *testing.T) {
+	kaf, err := NewKeyAggregatorFactory("slot,sum(size)")
+	if err != nil {
+		t.Error(err)
+	}
+	key := kaf.Key(model.Event{Key: "foo", Slot: 12182})
+	if len(key) != 1 || key[0] != "12182" {
+		t.Error(key)
+	}
+}
+
+func TestErrorField(t *testing.T) {
+	kaf, err := NewKeyAggregatorFactory("cmd,key,error,sum(size)")
+	if err != nil {
+		t.Error(err)
+	}
+	key := kaf.Key(model.Event{Type: model.EventError, Key: "key1", Error: "WRONGTYPE", Command: "read"})
+	if len(key) != 3 || key[0] != "read" || key[1] != "key1" || key[2] != "WRONGTYPE" {
+		t.Error(key)
+	}
+	key = kaf.Key(model.Event{Type: model.EventError, Error: "ERR"})
+	if len(key) != 3 || key[0] != "error" || key[1] != "" || key[2] != "ERR" {
+		t.Error(key)
+	}
+}
+
+func TestRouteField(t *testing.T) {
+	kaf, err := NewKeyAggregatorFactory("route,key,sum(size)")
+	if err != nil {
+		t.Error(err)
+	}
+	key := kaf.Key(model.Event{Key: "foo:123", Route: "/east/main/"})
+	if len(key) != 2 || key[0] != "/east/main/" || key[1] != "foo:123" {
+		t.Error(key)
+	}
+}
+
+func TestKeyPatternField(t *testing.T) {
+	kaf, err := NewKeyAggregatorFactory("keypattern,sum(size)")
+	if err != nil {
+		t.Error(err)
+	}
+	if !kaf.HasKeyField(model.FieldKeyPattern) || kaf.HasKeyField(model.FieldKey) {
+		t.Error("wrong key fields")
+	}
+	if !kaf.UsesField(model.FieldSize) || kaf.UsesField(model.FieldKey) {
+		t.Error("wrong fields used")
+	}
+	if key := kaf.Key(model.Event{Key: "user:1:profile"}); len(key) != 1 || key[0] != "user:1:profile" {
+		t.Error("key changed without a pattern:", key)
+	}
+	kaf.SetKeyPattern(func(key string) string { return strings.Replace(key, "1", "{num}", -1) })
+	key := kaf.Key(model.Event{Key: "user:1:profile"})
+	if len(key) != 1 || key[0] != "user:{num}:profile" {
+		t.Error(key)
+	}
+}
+
 func TestKeyAggregator(t *testing.T) {
 	kaf, err := NewKeyAggregatorFactory("key,max(size),sum(size),avg(size)")
 	if err != nil {
@@ -76,6 +179,26 @@ func TestPercentile(t *testing.T) {
 	}
 }
 
+func TestLatency(t *testing.T) {
+	kaf, err := NewKeyAggregatorFactory("key,max(latency),p50(latency)")
+	if err != nil {
+		t.Error(err)
+	}
+
+	ka := kaf.New()
+	for _, l := range []time.Duration{time.Millisecond, 2 * time.Millisecond, 30 * time.Millisecond} {
+		ka.Add(model.Event{Type: model.EventGetHit, Key: "key1", Latency: l})
+	}
+
+	res := ka.Result()
+	if res[0] != 30000 {
+		t.Error("max:", res[0])
+	}
+	if res[1] != 2000 {
+		t.Error("median:", res[1])
+	}
+}
+
 func eventsWithSizes(sizes ...int) []model.Event {
 	res := make([]model.Event, len(sizes))
 	for i, s := range sizes {
@@ -83,3 +206,113 @@ func eventsWithSizes(sizes ...int) []model.Event {
 	}
 	return res
 }
+
+func TestCount(t *testing.T) {
+	for _, desc := range []string{"key,count", "key,count()", "key,count(size)"} {
+		kaf, err := NewKeyAggregatorFactory(desc)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		ka := kaf.New()
+		for _, e := range eventsWithSizes(10, 20, 30) {
+			ka.Add(e)
+		}
+		if res := ka.Result(); res[0] != 3 {
+			t.Error(desc, res)
+		}
+	}
+}
+
+func TestRate(t *testing.T) {
+	kaf, err := NewKeyAggregatorFactory("key,rate(),rate(size),sum(size)")
+	if err != nil {
+		t.Error(err)
+	}
+	ka := kaf.New()
+	for _, e := range eventsWithSizes(10, 20, 30, 40) {
+		ka.Add(e)
+	}
+	res := ka.Result()
+	kaf.Normalize(res, 2*time.Second)
+	if res[0] != 2 || res[1] != 50 || res[2] != 100 {
+		t.Error(res)
+	}
+}
+
+func TestStdDev(t *testing.T) {
+	kaf, err := NewKeyAggregatorFactory("key,stddev(size)")
+	if err != nil {
+		t.Error(err)
+	}
+	ka := kaf.New()
+	for _, e := range eventsWithSizes(2, 4, 4, 4, 5, 5, 7, 9) {
+		ka.Add(e)
+	}
+	if res := ka.Result(); res[0] != 2 {
+		t.Error(res)
+	}
+}
+
+func TestFieldlessDescriptors(t *testing.T) {
+	for _, desc := range []string{"key,sum()", "key,max", "key,count(key)"} {
+		if _, err := NewKeyAggregatorFactory(desc); err == nil {
+			t.Error("expected error for", desc)
+		}
+	}
+}
+
+func TestHitMiss(t *testing.T) {
+	kaf, err := NewKeyAggregatorFactory("key,hits,misses(),hitratio")
+	if err != nil {
+		t.Error(err)
+	}
+	ka := kaf.New()
+	for _, typ := range []model.EventType{model.EventGetHit, model.EventGetMiss, model.EventGatHit, model.EventGetHit, model.EventSet} {
+		ka.Add(model.Event{Type: typ, Key: "key1"})
+	}
+	if res := ka.Result(); res[0] != 3 || res[1] != 1 || res[2] != 75 {
+		t.Error(res)
+	}
+
+	ka = kaf.New()
+	ka.Add(model.Event{Type: model.EventSet, Key: "key1"})
+	if res := ka.Result(); res[2] != 100 {
+		t.Error("hit ratio without retrievals:", res[2])
+	}
+}
+
+func TestDistinct(t *testing.T) {
+	kaf, err := NewKeyAggregatorFactory("keypattern,distinct(key)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ka := kaf.New()
+	for _, key := range []string{"user:1", "user:2", "user:1", "user:3"} {
+		ka.Add(model.Event{Key: key})
+	}
+	if res := ka.Result(); res[0] != 3 {
+		t.Error(res)
+	}
+	if _, err := NewKeyAggregatorFactory("key,distinct()"); err == nil {
+		t.Error("expected error for distinct without a field")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	kaf, err := NewKeyAggregatorFactory("key,sum(size),max(size),stddev(size),p50(size),count,distinct(size)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ka, other := kaf.New(), kaf.New()
+	for _, e := range eventsWithSizes(2, 4, 4, 4) {
+		ka.Add(e)
+	}
+	for _, e := range eventsWithSizes(5, 5, 7, 9) {
+		other.Add(e)
+	}
+	ka.Merge(other)
+	if res := ka.Result(); res[0] != 40 || res[1] != 9 || res[2] != 2 || res[3] != 4 || res[4] != 8 || res[5] != 5 {
+		t.Error(res)
+	}
+}
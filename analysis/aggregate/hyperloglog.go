@@ -0,0 +1,83 @@
+package aggregate
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// hllPrecision is the number of hash bits used to select a register.  With
+// 4096 registers the standard error of estimates is about 1.6%.
+const hllPrecision = 12
+
+// HyperLogLog estimates the number of distinct values added to it, in a
+// fixed amount of memory.  Values are added as 64-bit hashes.
+type HyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+}
+
+// Add records a value by its hash, as returned by HashString.
+func (h *HyperLogLog) Add(n int64) {
+	x := uint64(n)
+	idx := x >> (64 - hllPrecision)
+	// position of the first set bit in the remaining bits, counting from 1
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// Result returns the estimated number of distinct values added.
+func (h *HyperLogLog) Result() int64 {
+	m := float64(len(h.registers))
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small cardinalities
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(estimate))
+}
+
+func (h *HyperLogLog) Reset() {
+	h.registers = [len(h.registers)]uint8{}
+}
+
+// Merge adds all values recorded in other to h.
+func (h *HyperLogLog) Merge(other Aggregator) {
+	for i, r := range other.(*HyperLogLog).registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+// ByteSize returns the approximate memory used by this aggregator.
+func (h *HyperLogLog) ByteSize() int {
+	return len(h.registers)
+}
+
+// HashString returns a well-mixed 64-bit hash of s, for adding to a
+// HyperLogLog.
+func HashString(s string) int64 {
+	hash := fnv.New64a()
+	// writing to a Hash can never fail
+	_, _ = hash.Write([]byte(s))
+	// splitmix64 finalizer, since FNV leaves similar strings with similar
+	// high bits
+	x := hash.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return int64(x)
+}
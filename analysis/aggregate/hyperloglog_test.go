@@ -0,0 +1,37 @@
+package aggregate
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLogEstimate(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 10000, 1000000} {
+		var h HyperLogLog
+		for i := 0; i < n; i++ {
+			h.Add(HashString("user:" + strconv.Itoa(i)))
+			// duplicates do not count
+			h.Add(HashString("user:" + strconv.Itoa(i)))
+		}
+		est := h.Result()
+		if diff := float64(est - int64(n)); diff > 0.05*float64(n) || -diff > 0.05*float64(n) {
+			t.Error("expected about", n, "got", est)
+		}
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	var a, b HyperLogLog
+	for i := 0; i < 5000; i++ {
+		a.Add(HashString(strconv.Itoa(i)))
+		b.Add(HashString(strconv.Itoa(i + 2500)))
+	}
+	a.Merge(&b)
+	if est := a.Result(); est < 7000 || est > 8000 {
+		t.Error(est)
+	}
+	a.Reset()
+	if est := a.Result(); est != 0 {
+		t.Error(est)
+	}
+}
@@ -1,36 +1,43 @@
 package analysis
 
 import (
+	"fmt"
 	"github.com/box/memsniff/protocol/model"
 	"regexp"
 	"sync"
 )
 
-// filter is a threadsafe container for a regex.
+// filter is a threadsafe container for a regex and a set of commands.
 type filter struct {
 	sync.RWMutex
 	r *regexp.Regexp
+	// cmds is the set of command names to match, or nil to match all.
+	cmds map[string]bool
 }
 
 func (f *filter) filterEvents(rs []model.Event) []model.Event {
-	re := f.regex()
-	if re == nil {
+	re, cmds := f.criteria()
+	if re == nil && cmds == nil {
 		return rs
 	}
 
 	matches := make([]model.Event, 0, len(rs))
 	for _, r := range rs {
-		if re.MatchString(r.Key) {
-			matches = append(matches, r)
+		if cmds != nil && !cmds[r.CommandName()] {
+			continue
 		}
+		if re != nil && !re.MatchString(r.Key) {
+			continue
+		}
+		matches = append(matches, r)
 	}
 	return matches
 }
 
-func (f *filter) regex() *regexp.Regexp {
+func (f *filter) criteria() (*regexp.Regexp, map[string]bool) {
 	f.RLock()
 	defer f.RUnlock()
-	return f.r
+	return f.r, f.cmds
 }
 
 func (f *filter) setPattern(pattern string) (err error) {
@@ -48,3 +55,22 @@ func (f *filter) setPattern(pattern string) (err error) {
 
 	return
 }
+
+func (f *filter) setCommands(names []string) error {
+	var cmds map[string]bool
+	if len(names) > 0 {
+		cmds = make(map[string]bool, len(names))
+		for _, name := range names {
+			if !model.IsCommand(name) {
+				return fmt.Errorf("unknown command: %s", name)
+			}
+			cmds[name] = true
+		}
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	f.cmds = cmds
+
+	return nil
+}
@@ -52,6 +52,53 @@ func TestInvalidMatchesAll(t *testing.T) {
 	}
 }
 
+func TestCommandFilter(t *testing.T) {
+	f := &filter{}
+	_ = f.setCommands([]string{"delete", "get"})
+	evts := f.filterEvents([]model.Event{
+		{Type: model.EventGetMiss, Key: "a"},
+		{Type: model.EventSet, Key: "b"},
+		{Type: model.EventDelete, Key: "c"},
+	})
+	if len(evts) != 2 || evts[0].Key != "a" || evts[1].Key != "c" {
+		t.Error(evts)
+	}
+}
+
+func TestCommandAndPatternFilter(t *testing.T) {
+	f := &filter{}
+	_ = f.setCommands([]string{"delete"})
+	_ = f.setPattern("^c")
+	evts := f.filterEvents([]model.Event{
+		{Type: model.EventDelete, Key: "a"},
+		{Type: model.EventSet, Key: "c"},
+		{Type: model.EventDelete, Key: "c"},
+	})
+	if len(evts) != 1 || evts[0].Type != model.EventDelete || evts[0].Key != "c" {
+		t.Error(evts)
+	}
+}
+
+func TestCommandFilterErrors(t *testing.T) {
+	f := &filter{}
+	_ = f.setCommands([]string{"get"})
+	evts := f.filterEvents([]model.Event{
+		{Type: model.EventError, Key: "a", Error: "ERR", Command: "get"},
+		{Type: model.EventError, Key: "b", Error: "ERR", Command: "set"},
+		{Type: model.EventError, Key: "c", Error: "ERR"},
+	})
+	if len(evts) != 1 || evts[0].Key != "a" {
+		t.Error(evts)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	f := &filter{}
+	if f.setCommands([]string{"frobnicate"}) == nil {
+		t.Error("did not return error for unknown command")
+	}
+}
+
 func match(f *filter, key string) bool {
 	return len(f.filterEvents([]model.Event{
 		{
@@ -0,0 +1,80 @@
+package analysis
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// DefaultKeyRules are the key normalization rules used unless others are set.
+var DefaultKeyRules = []string{"uuid", "hex", "digits"}
+
+// builtinKeyRules maps the names of predefined normalization rules to the
+// pattern they replace and its placeholder.
+var builtinKeyRules = map[string][2]string{
+	"uuid":   {`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`, "{uuid}"},
+	"hex":    {`[0-9a-fA-F]{16,}`, "{hex}"},
+	"digits": {`[0-9]+`, "{num}"},
+}
+
+// keyRule replaces all matches of a pattern in a key with a placeholder.
+type keyRule struct {
+	re          *regexp.Regexp
+	placeholder string
+}
+
+// normalizer is a threadsafe list of rules that turn keys into patterns, so
+// that keys differing only in IDs or hashes aggregate together.
+type normalizer struct {
+	sync.RWMutex
+	rules []keyRule
+}
+
+// parseKeyRule parses a rule specification, either the name of a builtin rule
+// or a regex and placeholder of the form "regex=placeholder".
+func parseKeyRule(spec string) (keyRule, error) {
+	pattern, placeholder := spec, ""
+	if builtin, ok := builtinKeyRules[spec]; ok {
+		pattern, placeholder = builtin[0], builtin[1]
+	} else if i := strings.LastIndexByte(spec, '='); i > 0 {
+		pattern, placeholder = spec[:i], spec[i+1:]
+	} else {
+		return keyRule{}, fmt.Errorf("unknown key rule: %s", spec)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return keyRule{}, err
+	}
+	return keyRule{re: re, placeholder: placeholder}, nil
+}
+
+func (n *normalizer) setRules(specs []string) error {
+	rules := make([]keyRule, 0, len(specs))
+	for _, spec := range specs {
+		rule, err := parseKeyRule(spec)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	n.rules = rules
+
+	return nil
+}
+
+// normalize returns the pattern of key, found by applying every rule in
+// order.
+func (n *normalizer) normalize(key string) string {
+	n.RLock()
+	rules := n.rules
+	n.RUnlock()
+
+	for _, r := range rules {
+		key = r.re.ReplaceAllLiteralString(key, r.placeholder)
+	}
+	return key
+}
@@ -0,0 +1,81 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/analysis/aggregate"
+	"github.com/box/memsniff/protocol/model"
+	"testing"
+)
+
+func TestDefaultKeyRules(t *testing.T) {
+	n := &normalizer{}
+	if err := n.setRules(DefaultKeyRules); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"user:12345:profile":                           "user:{num}:profile",
+		"session:0f8fad5b-d9cb-469f-a165-70867728950e": "session:{uuid}",
+		"blob:9e107d9d372bb6826bd81d3542a419d6:v2":     "blob:{hex}:v{num}",
+		"config":             "config",
+		"page:42:comments:7": "page:{num}:comments:{num}",
+		"/east/main/user:1":  "/east/main/user:{num}",
+		"avatar:deadbeefdeadbeef0123456789abcdef01234567": "avatar:{hex}",
+	}
+	for key, expected := range cases {
+		if pattern := n.normalize(key); pattern != expected {
+			t.Error(key, pattern)
+		}
+	}
+}
+
+func TestCustomKeyRule(t *testing.T) {
+	n := &normalizer{}
+	if err := n.setRules([]string{"[a-z]+@[a-z.]+=<email>", "digits"}); err != nil {
+		t.Fatal(err)
+	}
+	if pattern := n.normalize("inbox:bob@example.com:3"); pattern != "inbox:<email>:{num}" {
+		t.Error(pattern)
+	}
+}
+
+func TestNoKeyRules(t *testing.T) {
+	n := &normalizer{}
+	if pattern := n.normalize("user:1"); pattern != "user:1" {
+		t.Error(pattern)
+	}
+}
+
+func TestBadKeyRules(t *testing.T) {
+	n := &normalizer{}
+	for _, spec := range []string{"nosuch", "[=x"} {
+		if err := n.setRules([]string{spec}); err == nil {
+			t.Error("expected error for", spec)
+		}
+	}
+}
+
+func TestKeyPatternAggregate(t *testing.T) {
+	n := &normalizer{}
+	if err := n.setRules(DefaultKeyRules); err != nil {
+		t.Fatal(err)
+	}
+	kaf, err := aggregate.NewKeyAggregatorFactory("cmd,distinct(keypattern)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kaf.SetKeyPattern(n.normalize)
+	evts := []model.Event{
+		{Type: model.EventGetHit, Key: "user:1"},
+		{Type: model.EventGetHit, Key: "user:2"},
+		{Type: model.EventGetHit, Key: "item:1"},
+	}
+	ka := kaf.New()
+	for _, e := range evts {
+		ka.Add(e)
+	}
+	if res := ka.Result(); res[0] != 2 {
+		t.Error(res)
+	}
+	if evts[0].Key != "user:1" {
+		t.Error("event modified:", evts[0])
+	}
+}
@@ -3,11 +3,16 @@
 package analysis
 
 import (
+	"errors"
+	"fmt"
 	"github.com/box/memsniff/analysis/aggregate"
 	"github.com/box/memsniff/log"
 	"github.com/box/memsniff/protocol/model"
 	"hash/fnv"
+	"sort"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Pool tracks datastore activity by hashing inputs to fixed workers.
@@ -20,9 +25,24 @@ type Pool struct {
 	Logger  log.Logger
 	workers []worker
 	filter  filter
+	keys    normalizer
 	stats   Stats
 
 	kaf aggregate.KeyAggregatorFactory
+
+	// mu protects resetAt, sortSpec, sortKeys, approximate and windows.
+	mu sync.Mutex
+	// resetAt is when data was last cleared, the start of the interval over
+	// which rates are calculated.
+	resetAt time.Time
+	// sortSpec is the column to sort by as passed to SetSortOrder.
+	sortSpec string
+	// sortKeys is the default order of reports.
+	sortKeys []sortKey
+	// approximate is set when workers track only the most frequent keys.
+	approximate bool
+	// windows are the lengths in seconds of rolling windows reported, if any.
+	windows []int
 }
 
 // Stats contains performance metrics for a Pool.
@@ -55,8 +75,16 @@ func New(numWorkers int, format string) (*Pool, error) {
 		return nil, err
 	}
 	p := &Pool{
-		kaf:     kaf,
 		workers: make([]worker, numWorkers),
+		resetAt: time.Now(),
+	}
+	if err := p.keys.setRules(DefaultKeyRules); err != nil {
+		return nil, err
+	}
+	kaf.SetKeyPattern(p.keys.normalize)
+	p.kaf = kaf
+	if err := p.SetSortOrder(""); err != nil {
+		return nil, err
 	}
 
 	for i := 0; i < numWorkers; i++ {
@@ -74,7 +102,8 @@ func New(numWorkers int, format string) (*Pool, error) {
 //
 // HandleEvents is threadsafe.
 func (p *Pool) HandleEvents(evts []model.Event) {
-	perWorkerEvents := p.partitionEvents(p.filter.filterEvents(evts))
+	evts = p.filter.filterEvents(evts)
+	perWorkerEvents := p.partitionEvents(evts)
 	for i, events := range perWorkerEvents {
 		if len(events) > 0 {
 			err := p.workers[i].handleEvents(events)
@@ -87,10 +116,12 @@ func (p *Pool) HandleEvents(evts []model.Event) {
 	}
 }
 
+// partitionEvents assigns events to workers by their key fields, so that all
+// events for a row of the report are aggregated by the same worker.
 func (p *Pool) partitionEvents(evts []model.Event) [][]model.Event {
 	perWorkerEvents := make([][]model.Event, len(p.workers))
 	for _, e := range evts {
-		slot := p.keySlot(e.Key)
+		slot := p.keySlot(p.kaf.FlatKey(e))
 		perWorkerEvents[slot] = append(perWorkerEvents[slot], e)
 	}
 	return perWorkerEvents
@@ -110,23 +141,157 @@ func (p *Pool) SetFilterPattern(pattern string) error {
 	return nil
 }
 
+// SetFilterCommands restricts future data points to operations named in cmds,
+// such as "get" or "delete".  Errors are included with the operation that
+// failed, as in the cmd field.  As with SetFilterPattern, current statistics
+// are cleared before returning.  If cmds is empty statistics are collected for all
+// operations.
+func (p *Pool) SetFilterCommands(cmds []string) error {
+	err := p.filter.setCommands(cmds)
+	if err != nil {
+		return err
+	}
+	p.Reset()
+	return nil
+}
+
+// SetKeyRules sets the rules used to derive the keypattern field from keys,
+// applied in order.  Each rule is one of "uuid", "hex" or "digits", or a
+// regex and the placeholder to replace its matches with, separated by "=".
+// As with SetFilterPattern, current statistics are cleared before returning.
+func (p *Pool) SetKeyRules(rules []string) error {
+	err := p.keys.setRules(rules)
+	if err != nil {
+		return err
+	}
+	p.Reset()
+	return nil
+}
+
+// SetMemoryBudget limits the memory used to track keys to approximately
+// budget bytes, by tracking only the most frequent keys in each worker.
+// Results for each key may then be missing some events, up to the MaxError
+// reported for the row.  A budget of zero tracks every key exactly.  Current
+// statistics are cleared before returning, unless nothing changes.
+// Approximate tracking cannot be combined with rolling windows.
+func (p *Pool) SetMemoryBudget(budget int) error {
+	var capacity int
+	if budget > 0 {
+		entrySize := p.kaf.EntrySize()
+		capacity = budget / len(p.workers) / entrySize
+		if capacity < 1 {
+			return fmt.Errorf("memory budget too small, need at least %d bytes", entrySize*len(p.workers))
+		}
+	}
+
+	p.mu.Lock()
+	if budget > 0 && len(p.windows) > 0 {
+		p.mu.Unlock()
+		return errors.New("cannot track keys approximately with rolling windows")
+	}
+	if budget == 0 && !p.approximate {
+		p.mu.Unlock()
+		return nil
+	}
+	p.approximate = budget > 0
+	p.mu.Unlock()
+
+	p.restartInterval(time.Now())
+	for _, w := range p.workers {
+		w.setMode(workerMode{capacity: capacity})
+	}
+	return nil
+}
+
+// SetWindows reports activity over rolling windows of the given lengths side
+// by side, instead of over the interval since the last report.  Each
+// aggregate is reported once for each window, as in "sum(size)/10s", and
+// rates are per second over the window.  Decayed rates (erate) instead
+// weight activity over the longest window by its age, with the window length
+// as the time constant.  Reports no longer clear data, which expires as it
+// leaves the longest window.  Lengths must be whole seconds.  Current
+// statistics are cleared before returning, unless nothing changes.
+func (p *Pool) SetWindows(lengths []time.Duration) error {
+	var secs []int
+	for _, l := range lengths {
+		if l < time.Second || l%time.Second != 0 {
+			return fmt.Errorf("window must be a whole number of seconds: %v", l)
+		}
+		secs = append(secs, int(l/time.Second))
+	}
+	sort.Ints(secs)
+	for i := 1; i < len(secs); i++ {
+		if secs[i] == secs[i-1] {
+			return fmt.Errorf("duplicate window: %ds", secs[i])
+		}
+	}
+
+	p.mu.Lock()
+	if len(secs) > 0 && p.approximate {
+		p.mu.Unlock()
+		return errors.New("cannot track keys approximately with rolling windows")
+	}
+	if len(secs) == 0 && len(p.windows) == 0 {
+		p.mu.Unlock()
+		return nil
+	}
+	sortKeys, err := p.resolveSort(p.sortSpec, secs)
+	if err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	p.windows = secs
+	p.sortKeys = sortKeys
+	p.mu.Unlock()
+
+	p.restartInterval(time.Now())
+	for _, w := range p.workers {
+		w.setMode(workerMode{windows: secs})
+	}
+	return nil
+}
+
 // Reset clears all recorded activity from this Pool.  This operation is
 // asynchronous, and may still be in progress when Reset returns.  New data
 // added by calling HandleGetResponse after Reset returns may be lost, and
 // results from Report immediately after a call to Reset may still contain some
 // information recorded before the call to Reset.
 func (p *Pool) Reset() {
+	p.restartInterval(time.Now())
 	for _, w := range p.workers {
 		w.reset()
 	}
 }
 
+// restartInterval records now as the start of a new interval, returning the
+// length of the previous one.
+func (p *Pool) restartInterval(now time.Time) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	interval := now.Sub(p.resetAt)
+	p.resetAt = now
+	return interval
+}
+
+// elapsed returns the time since data was last cleared.
+func (p *Pool) elapsed(now time.Time) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return now.Sub(p.resetAt)
+}
+
 // Stats returns a record of total activity reported to this Pool, including
 // input that was dropped due to not keeping up.
 func (p *Pool) Stats() Stats {
 	return p.stats
 }
 
+// UsesField returns true if the format of this Pool reports field id, so that
+// fields costly to decode can be skipped otherwise.
+func (p *Pool) UsesField(id model.EventFieldMask) bool {
+	return p.kaf.UsesField(id)
+}
+
 func (p *Pool) keySlot(key string) int {
 	hash := fnv.New64a()
 	// writing to a Hash can never fail
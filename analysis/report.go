@@ -1,7 +1,10 @@
 package analysis
 
 import (
+	"fmt"
+	"github.com/box/memsniff/analysis/aggregate"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -9,6 +12,9 @@ import (
 type ReportRow struct {
 	Key    []string
 	Values []int64
+	// MaxError is the most events that may be missing from Values, when keys
+	// are tracked approximately.
+	MaxError int64
 }
 
 // Report represents key activity submitted to a Pool since the last call to
@@ -19,15 +25,46 @@ type Report struct {
 	KeyColNames []string
 	ValColNames []string
 	Rows        []ReportRow
+	// Approximate is set if only the most frequent keys were tracked, so
+	// that rows may be missing some events.
+	Approximate bool
+	// DistinctKeys is the estimated number of distinct keys seen, which
+	// remains accurate when keys are tracked approximately.
+	DistinctKeys int64
+
+	// sortKeys is the order set with Pool.SetSortOrder.
+	sortKeys []sortKey
+}
+
+// sortKey identifies a column to sort reports by.
+type sortKey struct {
+	// column is numbered across the key columns followed by the value columns.
+	column     int
+	descending bool
 }
 
+// SortBy orders rows by the given columns in turn, numbered across the key
+// columns followed by the value columns.  Negative numbers sort descending.
 func (r *Report) SortBy(columns ...int) {
-	sort.Sort(&reportSort{r, columns})
+	keys := make([]sortKey, len(columns))
+	for i, col := range columns {
+		if col < 0 {
+			keys[i] = sortKey{column: -col, descending: true}
+		} else {
+			keys[i] = sortKey{column: col}
+		}
+	}
+	sort.Sort(&reportSort{r, keys})
+}
+
+// Sort orders rows as configured with Pool.SetSortOrder.
+func (r *Report) Sort() {
+	sort.Sort(&reportSort{r, r.sortKeys})
 }
 
 type reportSort struct {
-	report      *Report
-	sortColumns []int
+	report   *Report
+	sortKeys []sortKey
 }
 
 func (rs *reportSort) Len() int {
@@ -35,11 +72,8 @@ func (rs *reportSort) Len() int {
 }
 
 func (rs *reportSort) Less(a, b int) bool {
-	for _, col := range rs.sortColumns {
-		descending := col < 0
-		if descending {
-			col = -col
-		}
+	for _, key := range rs.sortKeys {
+		col, descending := key.column, key.descending
 
 		var onValue bool
 		if col >= len(rs.report.KeyColNames) {
@@ -90,25 +124,158 @@ func (rs *reportSort) Swap(a, b int) {
 // asynchronous operation across the workers in the pool, some information
 // may be carried over between successive reports, and some data may be
 // lost entirely.
+//
+// Rate aggregates are calculated over the time since data was last cleared.
+//
+// With rolling windows set by SetWindows, each aggregate is reported for each
+// window, and data in windows is kept regardless of shouldReset.
 func (p *Pool) Report(shouldReset bool) Report {
+	now := time.Now()
+	var interval time.Duration
+	if shouldReset {
+		interval = p.restartInterval(now)
+	} else {
+		interval = p.elapsed(now)
+	}
+
+	windows := p.windowLengths()
 	var rows []ReportRow
+	var distinctKeys aggregate.HyperLogLog
 	for _, w := range p.workers {
 		workerEntries := w.result()
+		distinctKeys.Merge(&workerEntries.distinctKeys)
 		if shouldReset {
-			w.reset()
+			w.resetInterval()
 		}
 		for i := range workerEntries.keyFields {
 			row := ReportRow{
 				Key:    workerEntries.keyFields[i],
 				Values: workerEntries.aggResults[i],
 			}
+			if workerEntries.maxErrors != nil {
+				row.MaxError = workerEntries.maxErrors[i]
+			}
+			if windows == nil {
+				// workers normalize windows themselves
+				p.kaf.Normalize(row.Values, interval)
+			}
 			rows = append(rows, row)
 		}
 	}
 	return Report{
-		Timestamp:   time.Now(),
-		KeyColNames: p.kaf.KeyFields,
-		ValColNames: p.kaf.AggFields,
-		Rows:        rows,
+		Timestamp:    now,
+		KeyColNames:  p.kaf.KeyFields,
+		ValColNames:  p.valColNames(windows),
+		Rows:         rows,
+		Approximate:  p.isApproximate(),
+		DistinctKeys: distinctKeys.Result(),
+		sortKeys:     p.sortOrder(),
+	}
+}
+
+func (p *Pool) isApproximate() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.approximate
+}
+
+func (p *Pool) windowLengths() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.windows
+}
+
+// valColNames returns the names of the value columns of reports.  With
+// rolling windows, every aggregate is repeated for each window in turn,
+// qualified with the window length.
+func (p *Pool) valColNames(windows []int) []string {
+	if len(windows) == 0 {
+		return p.kaf.AggFields
+	}
+	names := make([]string, 0, len(windows)*len(p.kaf.AggFields))
+	for _, l := range windows {
+		for _, agg := range p.kaf.AggFields {
+			names = append(names, agg+"/"+windowName(l))
+		}
 	}
+	return names
+}
+
+// SetSortOrder sets the column that Report.Sort orders reports by.  spec names
+// a field or aggregate of the format, optionally prefixed with "+" to sort
+// ascending or "-" to sort descending.  Without a prefix, fields and hit
+// ratios sort ascending, so that keys that miss most come first, and other
+// aggregates descending.  If spec is empty, reports are sorted by sum(size)
+// if present, or else by the first aggregate.  With rolling windows, an
+// aggregate without a window, such as "sum(size)", refers to the shortest
+// window.
+func (p *Pool) SetSortOrder(spec string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sortKeys, err := p.resolveSort(spec, p.windows)
+	if err != nil {
+		return err
+	}
+	p.sortSpec = spec
+	p.sortKeys = sortKeys
+	return nil
+}
+
+// resolveSort returns the sort order for spec as passed to SetSortOrder,
+// when reporting the given windows.
+func (p *Pool) resolveSort(spec string, windows []int) ([]sortKey, error) {
+	names := append(append([]string{}, p.kaf.KeyFields...), p.valColNames(windows)...)
+	name := strings.TrimLeft(spec, "+-")
+	if name == "" {
+		name = "sum(size)"
+		if !containsString(p.kaf.AggFields, name) {
+			if len(p.kaf.AggFields) > 0 {
+				name = p.kaf.AggFields[0]
+			} else {
+				name = names[0]
+			}
+		}
+	}
+	col := -1
+	for i, n := range names {
+		if n == name {
+			col = i
+		}
+	}
+	if col < 0 && len(windows) > 0 {
+		for i, n := range p.kaf.AggFields {
+			if n == name {
+				// first window's columns come first
+				col = len(p.kaf.KeyFields) + i
+			}
+		}
+	}
+	if col < 0 {
+		return nil, fmt.Errorf("unknown sort column: %s", spec)
+	}
+	name = names[col]
+
+	descending := col >= len(p.kaf.KeyFields) && !strings.HasPrefix(name, "hitratio")
+	switch {
+	case strings.HasPrefix(spec, "+"):
+		descending = false
+	case strings.HasPrefix(spec, "-"):
+		descending = true
+	}
+	return []sortKey{{column: col, descending: descending}}, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Pool) sortOrder() []sortKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sortKeys
 }
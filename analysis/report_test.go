@@ -0,0 +1,122 @@
+package analysis
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSetSortOrder(t *testing.T) {
+	p, err := New(1, "key,max(size),sum(size),hitratio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string][]sortKey{
+		"":           {{2, true}},
+		"max(size)":  {{1, true}},
+		"+sum(size)": {{2, false}},
+		"hitratio":   {{3, false}},
+		"-hitratio":  {{3, true}},
+		"key":        {{0, false}},
+		"-key":       {{0, true}},
+	}
+	for spec, expected := range cases {
+		if err := p.SetSortOrder(spec); err != nil {
+			t.Error(spec, err)
+			continue
+		}
+		if cols := p.sortOrder(); !reflect.DeepEqual(cols, expected) {
+			t.Error(spec, cols)
+		}
+	}
+	for _, spec := range []string{"nosuch", "-nosuch"} {
+		if err := p.SetSortOrder(spec); err == nil {
+			t.Error("expected error for", spec)
+		}
+	}
+}
+
+func TestSortAscending(t *testing.T) {
+	r := Report{
+		KeyColNames: []string{"key"},
+		ValColNames: []string{"hitratio"},
+		Rows: []ReportRow{
+			{Key: []string{"a"}, Values: []int64{90}},
+			{Key: []string{"b"}, Values: []int64{10}},
+			{Key: []string{"c"}, Values: []int64{50}},
+		},
+		sortKeys: []sortKey{{1, false}},
+	}
+	r.Sort()
+	if r.Rows[0].Key[0] != "b" || r.Rows[1].Key[0] != "c" || r.Rows[2].Key[0] != "a" {
+		t.Error(r.Rows)
+	}
+}
+
+func TestSortDescendingByFirstColumn(t *testing.T) {
+	r := Report{
+		KeyColNames: []string{"key"},
+		ValColNames: []string{"sum(size)"},
+		Rows: []ReportRow{
+			{Key: []string{"a"}, Values: []int64{1}},
+			{Key: []string{"c"}, Values: []int64{1}},
+			{Key: []string{"b"}, Values: []int64{1}},
+		},
+		sortKeys: []sortKey{{0, true}},
+	}
+	r.Sort()
+	if r.Rows[0].Key[0] != "c" || r.Rows[1].Key[0] != "b" || r.Rows[2].Key[0] != "a" {
+		t.Error(r.Rows)
+	}
+}
+
+func TestSetWindows(t *testing.T) {
+	p, err := New(1, "key,sum(size),erate()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetSortOrder("erate()"); err != nil {
+		t.Fatal(err)
+	}
+	for _, lengths := range [][]time.Duration{
+		{500 * time.Millisecond},
+		{time.Second, time.Second},
+	} {
+		if err := p.SetWindows(lengths); err == nil {
+			t.Error("expected error for", lengths)
+		}
+	}
+	if err := p.SetWindows([]time.Duration{time.Minute, time.Second, 10 * time.Second}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"sum(size)/1s", "erate()/1s", "sum(size)/10s", "erate()/10s", "sum(size)/1m", "erate()/1m"}
+	if names := p.Report(true).ValColNames; !reflect.DeepEqual(names, expected) {
+		t.Error(names)
+	}
+	if cols := p.sortOrder(); !reflect.DeepEqual(cols, []sortKey{{2, true}}) {
+		t.Error("sort not resolved to shortest window:", cols)
+	}
+	if err := p.SetSortOrder("sum(size)/10s"); err != nil {
+		t.Error(err)
+	}
+	if cols := p.sortOrder(); !reflect.DeepEqual(cols, []sortKey{{3, true}}) {
+		t.Error(cols)
+	}
+	if err := p.SetMemoryBudget(64 * 1024 * 1024); err == nil {
+		t.Error("expected error for approximate tracking with windows")
+	}
+
+	if err := p.SetWindows(nil); err == nil {
+		t.Error("expected error for sort column of removed window")
+	}
+	if err := p.SetSortOrder(""); err != nil {
+		t.Error(err)
+	}
+	if err := p.SetWindows(nil); err != nil {
+		t.Error(err)
+	}
+	if names := p.Report(true).ValColNames; !reflect.DeepEqual(names, []string{"sum(size)", "erate()"}) {
+		t.Error(names)
+	}
+}
@@ -0,0 +1,107 @@
+package analysis
+
+import (
+	"container/heap"
+	"github.com/box/memsniff/analysis/aggregate"
+	"github.com/box/memsniff/protocol/model"
+)
+
+// topK tracks aggregates for approximately the most frequent keys in a fixed
+// number of entries, using the Space-Saving algorithm.  When a new key arrives
+// and every entry is in use, the entry seen least often is reassigned to the
+// new key.  The new key inherits the count of the evicted one, which bounds
+// the number of its earlier events that went untracked.
+type topK struct {
+	capacity int
+	entries  map[string]*topKEntry
+	// byCount orders entries so the least frequent is evicted first.
+	byCount topKHeap
+}
+
+type topKEntry struct {
+	mapKey string
+	ka     aggregate.KeyAggregator
+	// count is the estimated number of events for this key.
+	count int64
+	// maxError is the most that count can overestimate the true number of
+	// events, which is also the most events missing from ka.
+	maxError int64
+	// index is the position of this entry in topK.byCount.
+	index int
+}
+
+func newTopK(capacity int) *topK {
+	return &topK{
+		capacity: capacity,
+		entries:  make(map[string]*topKEntry, capacity),
+		byCount:  make(topKHeap, 0, capacity),
+	}
+}
+
+// add records evt against the entry for mapKey, creating or reassigning an
+// entry if necessary.
+func (t *topK) add(mapKey string, evt model.Event, kaf aggregate.KeyAggregatorFactory) {
+	e, ok := t.entries[mapKey]
+	if !ok {
+		if len(t.byCount) < t.capacity {
+			e = &topKEntry{ka: kaf.New()}
+			heap.Push(&t.byCount, e)
+		} else {
+			e = t.byCount[0]
+			delete(t.entries, e.mapKey)
+			e.ka.Reset()
+			e.maxError = e.count
+		}
+		e.mapKey = mapKey
+		e.ka.Key = kaf.Key(evt)
+		t.entries[mapKey] = e
+	}
+	e.count++
+	heap.Fix(&t.byCount, e.index)
+	e.ka.Add(evt)
+}
+
+func (t *topK) reset() {
+	for k := range t.entries {
+		delete(t.entries, k)
+	}
+	t.byCount = t.byCount[:0]
+}
+
+func (t *topK) results() (res result) {
+	res.keyFields = make([][]string, len(t.byCount))
+	res.aggResults = make([][]int64, len(t.byCount))
+	res.maxErrors = make([]int64, len(t.byCount))
+	for i, e := range t.byCount {
+		res.keyFields[i] = e.ka.Key
+		res.aggResults[i] = e.ka.Result()
+		res.maxErrors[i] = e.maxError
+	}
+	return
+}
+
+// topKHeap is a min-heap of entries by count, implementing heap.Interface.
+type topKHeap []*topKEntry
+
+func (h topKHeap) Len() int { return len(h) }
+
+func (h topKHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topKHeap) Push(x interface{}) {
+	e := x.(*topKEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,78 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/analysis/aggregate"
+	"github.com/box/memsniff/protocol/model"
+	"testing"
+)
+
+func TestTopKEvictsLeastFrequent(t *testing.T) {
+	kaf, err := aggregate.NewKeyAggregatorFactory("key,count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := newTopK(2)
+	for _, key := range []string{"a", "b", "a", "b", "a", "c", "a", "c"} {
+		evt := model.Event{Key: key}
+		tk.add(kaf.FlatKey(evt), evt, kaf)
+	}
+
+	res := tk.results()
+	if len(res.keyFields) != 2 {
+		t.Fatal(res.keyFields)
+	}
+	found := make(map[string]int)
+	for i, k := range res.keyFields {
+		found[k[0]] = i
+	}
+	if _, ok := found["b"]; ok {
+		t.Error("least frequent key was not evicted")
+	}
+	if i := found["a"]; res.aggResults[i][0] != 4 || res.maxErrors[i] != 0 {
+		t.Error("a:", res.aggResults[i], res.maxErrors[i])
+	}
+	// c replaced b after two events, and saw two events itself
+	if i := found["c"]; res.aggResults[i][0] != 2 || res.maxErrors[i] != 2 {
+		t.Error("c:", res.aggResults[i], res.maxErrors[i])
+	}
+}
+
+func TestTopKReset(t *testing.T) {
+	kaf, err := aggregate.NewKeyAggregatorFactory("key,count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := newTopK(1)
+	evt := model.Event{Key: "a"}
+	tk.add(kaf.FlatKey(evt), evt, kaf)
+	tk.reset()
+	if res := tk.results(); len(res.keyFields) != 0 {
+		t.Error(res.keyFields)
+	}
+	tk.add(kaf.FlatKey(evt), evt, kaf)
+	if res := tk.results(); len(res.keyFields) != 1 || res.maxErrors[0] != 0 {
+		t.Error(res)
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	p, err := New(4, "key,sum(size),p99(latency)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetMemoryBudget(1024); err == nil {
+		t.Error("expected error for tiny budget")
+	}
+	if err := p.SetMemoryBudget(64 * 1024 * 1024); err != nil {
+		t.Error(err)
+	}
+	if !p.Report(false).Approximate {
+		t.Error("report not marked approximate")
+	}
+	if err := p.SetMemoryBudget(0); err != nil {
+		t.Error(err)
+	}
+	if p.Report(false).Approximate {
+		t.Error("report marked approximate")
+	}
+}
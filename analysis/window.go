@@ -0,0 +1,186 @@
+package analysis
+
+import (
+	"fmt"
+	"github.com/box/memsniff/analysis/aggregate"
+	"github.com/box/memsniff/protocol/model"
+	"math"
+	"time"
+)
+
+// windows keeps aggregates for each key in a ring buffer of one second
+// slots, so that activity over several trailing windows can be reported side
+// by side without clearing data.  Only complete seconds are reported.
+type windows struct {
+	// lengths are the window lengths to report in seconds, in ascending order.
+	lengths []int
+	// slots holds the aggregates for the most recent seconds, indexed by
+	// time modulo the number of slots.
+	slots []windowSlot
+	// start is the Unix time of the second in which tracking began.
+	start int64
+	// free holds cleared aggregators from expired slots for reuse, since
+	// aggregators such as percentiles are expensive to allocate.
+	free []aggregate.KeyAggregator
+	// scratch is reused to merge slots when reporting.
+	scratch *aggregate.KeyAggregator
+}
+
+type windowSlot struct {
+	// sec is the Unix time of the second whose events are held in aggregators.
+	sec         int64
+	aggregators map[string]aggregate.KeyAggregator
+}
+
+func newWindows(lengths []int, now int64) *windows {
+	w := &windows{
+		lengths: lengths,
+		// one more than the longest window for the second in progress
+		slots: make([]windowSlot, lengths[len(lengths)-1]+1),
+	}
+	w.reset(now)
+	return w
+}
+
+func (w *windows) slot(sec int64) *windowSlot {
+	return &w.slots[sec%int64(len(w.slots))]
+}
+
+// add records evt against mapKey in the slot for the second now.
+func (w *windows) add(now int64, mapKey string, evt model.Event, kaf aggregate.KeyAggregatorFactory) {
+	s := w.slot(now)
+	if s.sec != now {
+		w.expire(s)
+		s.sec = now
+	}
+	ka, ok := s.aggregators[mapKey]
+	if !ok {
+		if n := len(w.free); n > 0 {
+			ka = w.free[n-1]
+			w.free = w.free[:n-1]
+		} else {
+			ka = kaf.New()
+		}
+		ka.Key = kaf.Key(evt)
+		s.aggregators[mapKey] = ka
+	}
+	ka.Add(evt)
+}
+
+// expire clears s, keeping its aggregators for reuse.
+func (w *windows) expire(s *windowSlot) {
+	if s.aggregators == nil {
+		s.aggregators = make(map[string]aggregate.KeyAggregator)
+	}
+	for mapKey, ka := range s.aggregators {
+		ka.Reset()
+		w.free = append(w.free, ka)
+		delete(s.aggregators, mapKey)
+	}
+	s.sec = -1
+}
+
+func (w *windows) reset(now int64) {
+	for i := range w.slots {
+		w.expire(&w.slots[i])
+	}
+	w.start = now
+}
+
+// results returns the results for every key seen in the longest window.
+// The results for each window are concatenated in order of window length.
+func (w *windows) results(now int64, kaf aggregate.KeyAggregatorFactory) (res result) {
+	// complete seconds, most recent first, with nil for seconds without events
+	avail := int(now - w.start)
+	if avail > len(w.slots)-1 {
+		avail = len(w.slots) - 1
+	}
+	slots := make([]*windowSlot, avail)
+	keys := make(map[string][]string)
+	for i := range slots {
+		sec := now - int64(i+1)
+		if s := w.slot(sec); s.sec == sec {
+			slots[i] = s
+			for mapKey, ka := range s.aggregators {
+				keys[mapKey] = ka.Key
+			}
+		}
+	}
+
+	for mapKey, key := range keys {
+		var values []int64
+		for _, length := range w.lengths {
+			values = append(values, w.windowResult(slots, mapKey, length, kaf)...)
+		}
+		res.keyFields = append(res.keyFields, key)
+		res.aggResults = append(res.aggResults, values)
+	}
+	return
+}
+
+// windowResult returns the results for mapKey over the most recent length
+// seconds in slots.  Decayed rates instead weight every second in slots by
+// its age, with length as the time constant.
+func (w *windows) windowResult(slots []*windowSlot, mapKey string, length int, kaf aggregate.KeyAggregatorFactory) []int64 {
+	n := length
+	if n > len(slots) {
+		n = len(slots)
+	}
+	if w.scratch == nil {
+		merged := kaf.New()
+		w.scratch = &merged
+	}
+	merged := w.scratch
+	merged.Reset()
+	for _, s := range slots[:n] {
+		if s == nil {
+			continue
+		}
+		if ka, ok := s.aggregators[mapKey]; ok {
+			merged.Merge(ka)
+		}
+	}
+	values := merged.Result()
+	kaf.Normalize(values, time.Duration(n)*time.Second)
+
+	hasDecayed := false
+	for i := range values {
+		hasDecayed = hasDecayed || kaf.IsDecayed(i)
+	}
+	if !hasDecayed {
+		return values
+	}
+
+	decayed := make([]float64, len(values))
+	var totalWeight float64
+	for age, s := range slots {
+		weight := math.Exp(-float64(age) / float64(length))
+		totalWeight += weight
+		if s == nil {
+			continue
+		}
+		if ka, ok := s.aggregators[mapKey]; ok {
+			for i, v := range ka.Result() {
+				decayed[i] += weight * float64(v)
+			}
+		}
+	}
+	for i := range values {
+		if kaf.IsDecayed(i) && totalWeight > 0 {
+			values[i] = int64(math.Round(decayed[i] / totalWeight))
+		}
+	}
+	return values
+}
+
+// windowName formats a window length in seconds for use in a column name.
+func windowName(length int) string {
+	switch {
+	case length%3600 == 0:
+		return fmt.Sprintf("%dh", length/3600)
+	case length%60 == 0:
+		return fmt.Sprintf("%dm", length/60)
+	default:
+		return fmt.Sprintf("%ds", length)
+	}
+}
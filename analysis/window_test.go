@@ -0,0 +1,83 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/analysis/aggregate"
+	"github.com/box/memsniff/protocol/model"
+	"reflect"
+	"testing"
+)
+
+func windowValues(res result) map[string][]int64 {
+	values := make(map[string][]int64)
+	for i, k := range res.keyFields {
+		values[k[0]] = res.aggResults[i]
+	}
+	return values
+}
+
+func TestWindows(t *testing.T) {
+	kaf, err := aggregate.NewKeyAggregatorFactory("key,sum(size),rate(),erate()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := func(w *windows, sec int64, key string, size int) {
+		evt := model.Event{Key: key, Size: size}
+		w.add(sec, kaf.FlatKey(evt), evt, kaf)
+	}
+
+	w := newWindows([]int{1, 3}, 100)
+	add(w, 100, "a", 5)
+	add(w, 100, "c", 3)
+	for i := 0; i < 10; i++ {
+		add(w, 102, "a", 1)
+	}
+	add(w, 102, "b", 7)
+
+	if res := w.results(100, kaf); len(res.keyFields) != 0 {
+		t.Error("incomplete second reported:", res.keyFields)
+	}
+
+	expected := map[string][]int64{
+		"a": {10, 10, 7, 15, 4, 5},
+		"b": {7, 1, 1, 7, 0, 0},
+		"c": {0, 0, 0, 3, 0, 0},
+	}
+	if values := windowValues(w.results(103, kaf)); !reflect.DeepEqual(values, expected) {
+		t.Error(values)
+	}
+
+	// second 104 reuses the slot of second 100, which expires, and one of
+	// its aggregators
+	add(w, 104, "d", 2)
+	if len(w.free) != 1 {
+		t.Error("expired aggregators not reused:", len(w.free))
+	}
+	expected = map[string][]int64{
+		"a": {0, 0, 1, 10, 3, 2},
+		"b": {0, 0, 0, 7, 0, 0},
+		"d": {2, 1, 1, 2, 0, 0},
+	}
+	if values := windowValues(w.results(105, kaf)); !reflect.DeepEqual(values, expected) {
+		t.Error(values)
+	}
+
+	w.reset(105)
+	if res := w.results(106, kaf); len(res.keyFields) != 0 {
+		t.Error("data kept after reset:", res.keyFields)
+	}
+}
+
+func TestWindowName(t *testing.T) {
+	cases := map[int]string{
+		1:    "1s",
+		90:   "90s",
+		60:   "1m",
+		600:  "10m",
+		7200: "2h",
+	}
+	for length, expected := range cases {
+		if name := windowName(length); name != expected {
+			t.Error(length, name)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/box/memsniff/analysis/aggregate"
 	"github.com/box/memsniff/protocol/model"
 	"sync"
+	"time"
 )
 
 var (
@@ -19,13 +20,35 @@ type worker struct {
 	resRequest chan struct{}
 	// channel for data summaries
 	resReply chan result
-	// channel for requests to reset all data t to an empty state
+	// channel for requests to reset data to an empty state, true to clear
+	// everything or false for the end of a report interval
 	resetRequest chan bool
+	// channel for requests to change how keys are tracked
+	modeRequest chan workerMode
 
 	// create KeyAggregators based on the configured format
 	aggregatorFactory aggregate.KeyAggregatorFactory
 	// one KeyAggregator per key, where key is determined by aggregatorFactory
 	aggregators map[string]aggregate.KeyAggregator
+	// approximate tracking of the most frequent keys, used instead of
+	// aggregators if not nil
+	topK *topK
+	// rolling windows of recent activity, used instead of aggregators if
+	// not nil
+	windows *windows
+	// estimate of the number of distinct keys in all events handled
+	distinctKeys *aggregate.HyperLogLog
+	// now returns the current time, for assigning events to windows
+	now func() time.Time
+}
+
+// workerMode selects how a worker tracks keys.  At most one of its fields is
+// set, and if none are every key is tracked exactly until reset.
+type workerMode struct {
+	// capacity limits tracking to approximately the most frequent keys.
+	capacity int
+	// windows are the lengths in seconds of rolling windows to report.
+	windows []int
 }
 
 // errQueueFull is returned by handleGetResponse if the worker cannot keep
@@ -38,9 +61,12 @@ func newWorker(kaf aggregate.KeyAggregatorFactory) worker {
 		resRequest:   make(chan struct{}),
 		resReply:     make(chan result),
 		resetRequest: make(chan bool),
+		modeRequest:  make(chan workerMode),
 
 		aggregatorFactory: kaf,
 		aggregators:       make(map[string]aggregate.KeyAggregator),
+		distinctKeys:      &aggregate.HyperLogLog{},
+		now:               time.Now,
 	}
 	go w.loop()
 	return w
@@ -74,6 +100,18 @@ func (w *worker) reset() {
 	w.resetRequest <- true
 }
 
+// resetInterval clears key data at the end of a report interval.  Rolling
+// windows are retained, since they expire data on their own.
+func (w *worker) resetInterval() {
+	w.resetRequest <- false
+}
+
+// setMode changes how keys are tracked by this worker, clearing all tracked
+// data.
+func (w *worker) setMode(mode workerMode) {
+	w.modeRequest <- mode
+}
+
 // close exits this worker. Calls to handleEvents after calling close
 // will panic.
 func (w *worker) close() {
@@ -94,13 +132,35 @@ func (w *worker) loop() {
 		case <-w.resRequest:
 			w.resReply <- w.assembleResults()
 
-		case <-w.resetRequest:
-			w.resetAggregators()
+		case all := <-w.resetRequest:
+			w.resetAggregators(all)
+
+		case mode := <-w.modeRequest:
+			w.resetAggregators(true)
+			w.topK = nil
+			w.windows = nil
+			if mode.capacity > 0 {
+				w.topK = newTopK(mode.capacity)
+			}
+			if len(mode.windows) > 0 {
+				w.windows = newWindows(mode.windows, w.now().Unix())
+			}
 		}
 	}
 }
 
-func (w *worker) resetAggregators() {
+func (w *worker) resetAggregators(all bool) {
+	w.distinctKeys.Reset()
+	if w.windows != nil {
+		if all {
+			w.windows.reset(w.now().Unix())
+		}
+		return
+	}
+	if w.topK != nil {
+		w.topK.reset()
+		return
+	}
 	for key, ka := range w.aggregators {
 		delete(w.aggregators, key)
 		ka.Reset()
@@ -109,7 +169,16 @@ func (w *worker) resetAggregators() {
 }
 
 func (w *worker) handleEvent(evt model.Event) {
+	w.distinctKeys.Add(aggregate.HashString(evt.Key))
 	mapKey := w.aggregatorFactory.FlatKey(evt)
+	if w.topK != nil {
+		w.topK.add(mapKey, evt, w.aggregatorFactory)
+		return
+	}
+	if w.windows != nil {
+		w.windows.add(w.now().Unix(), mapKey, evt, w.aggregatorFactory)
+		return
+	}
 	ka, ok := w.aggregators[mapKey]
 	if !ok {
 		// Need to create an aggregator for this key.
@@ -133,16 +202,28 @@ type result struct {
 	keyFields [][]string
 	// aggResults[x] is the aggregate results for keyFields[x], in format-determined order.
 	aggResults [][]int64
+	// maxErrors[x] is the most events for keyFields[x] that may be missing
+	// from aggResults[x] when tracking keys approximately, or nil otherwise.
+	maxErrors []int64
+	// distinctKeys estimates the number of distinct keys handled.
+	distinctKeys aggregate.HyperLogLog
 }
 
 func (w *worker) assembleResults() (res result) {
-	res.keyFields = make([][]string, len(w.aggregators))
-	res.aggResults = make([][]int64, len(w.aggregators))
-	var i int
-	for _, ka := range w.aggregators {
-		res.keyFields[i] = ka.Key
-		res.aggResults[i] = ka.Result()
-		i++
+	if w.topK != nil {
+		res = w.topK.results()
+	} else if w.windows != nil {
+		res = w.windows.results(w.now().Unix(), w.aggregatorFactory)
+	} else {
+		res.keyFields = make([][]string, len(w.aggregators))
+		res.aggResults = make([][]int64, len(w.aggregators))
+		var i int
+		for _, ka := range w.aggregators {
+			res.keyFields[i] = ka.Key
+			res.aggResults[i] = ka.Result()
+			i++
+		}
 	}
+	res.distinctKeys = *w.distinctKeys
 	return
 }
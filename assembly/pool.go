@@ -13,14 +13,16 @@ type Pool struct {
 	workers []worker
 }
 
-// New creates a new pool for reassembling TCP streams.
-func New(logger log.Logger, analysis *analysis.Pool, protocol model.ProtocolType, ports []int, numWorkers int) *Pool {
+// New creates a new pool for reassembling TCP streams.  Connections to ports
+// in portProtocols are decoded with the protocol given there, and all others
+// with protocol, adjusted by opts.
+func New(logger log.Logger, analysis *analysis.Pool, protocol *model.Protocol, ports []int, portProtocols map[int]*model.Protocol, opts model.Options, numWorkers int) *Pool {
 	p := &Pool{
 		logger,
 		make([]worker, numWorkers),
 	}
 	for i := 0; i < numWorkers; i++ {
-		p.workers[i] = newWorker(logger, analysis, protocol, ports)
+		p.workers[i] = newWorker(logger, analysis, protocol, ports, portProtocols, opts)
 	}
 	return p
 }
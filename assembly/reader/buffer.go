@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"time"
 )
 
 type Buffer struct {
@@ -13,6 +14,8 @@ type Buffer struct {
 	cap     int
 	blocks  []block
 	discard int
+	// capture time of the most recently consumed data
+	lastSeen time.Time
 }
 
 func NewBuffer(cap int) *Buffer {
@@ -36,9 +39,17 @@ func (b *Buffer) Reset() {
 	b.len = 0
 	b.blocks = b.blocks[:0]
 	b.discard = 0
+	b.lastSeen = time.Time{}
 }
 
+// Write appends data to the buffer, preceded by a gap of skip lost bytes.
 func (b *Buffer) Write(skip int, data []byte) error {
+	return b.WriteSeen(skip, data, time.Time{})
+}
+
+// WriteSeen appends data to the buffer like Write, recording that the data
+// was captured at time seen.
+func (b *Buffer) WriteSeen(skip int, data []byte, seen time.Time) error {
 	if skip < 0 {
 		// starting mid-conversation
 		skip = 0
@@ -46,6 +57,7 @@ func (b *Buffer) Write(skip int, data []byte) error {
 	if b.discard >= skip+len(data) {
 		// discard all of data
 		b.discard = b.discard - skip - len(data)
+		b.lastSeen = seen
 		return nil
 	}
 
@@ -54,6 +66,7 @@ func (b *Buffer) Write(skip int, data []byte) error {
 	} else {
 		data = data[b.discard-skip:]
 		skip = 0
+		b.lastSeen = seen
 	}
 
 	if b.buf.Len()+len(data) > b.cap {
@@ -61,15 +74,35 @@ func (b *Buffer) Write(skip int, data []byte) error {
 	}
 	b.buf.Write(data)
 	b.discard = 0
-	if skip == 0 && len(b.blocks) > 0 {
+	if skip == 0 && len(b.blocks) > 0 && b.blocks[len(b.blocks)-1].seen.Equal(seen) {
 		b.blocks[len(b.blocks)-1].dataLen += len(data)
 	} else {
-		b.blocks = append(b.blocks, block{skip, len(data)})
+		b.blocks = append(b.blocks, block{skip, len(data), seen})
 	}
 	b.len += skip + len(data)
 	return nil
 }
 
+// Seen returns the capture time of the next unread byte, or the zero time if
+// no data is buffered.
+func (b *Buffer) Seen() time.Time {
+	if len(b.blocks) == 0 {
+		return time.Time{}
+	}
+	return b.blocks[0].seen
+}
+
+// LastSeen returns the capture time of the most recently read or discarded byte.
+func (b *Buffer) LastSeen() time.Time {
+	return b.lastSeen
+}
+
+// Discarding returns the number of bytes yet to arrive that will be
+// discarded.
+func (b *Buffer) Discarding() int {
+	return b.discard
+}
+
 func (b *Buffer) Len() int {
 	return b.len
 }
@@ -144,10 +177,16 @@ func (b *Buffer) Discard(n int) {
 	for i, block := range b.blocks {
 		l := block.len()
 		if l > toDiscard {
+			if toDiscard > block.gap {
+				b.lastSeen = block.seen
+			}
 			b.blocks[i].discard(b, toDiscard)
 			b.dropBlocks(i)
 			return
 		}
+		if block.dataLen > 0 {
+			b.lastSeen = block.seen
+		}
 		toDiscard -= l
 	}
 	b.buf.Reset()
@@ -197,6 +236,8 @@ type block struct {
 	gap int
 	// number of bytes of data
 	dataLen int
+	// capture time of the data
+	seen time.Time
 }
 
 func (b block) hasGap() bool {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"testing"
+	"time"
 )
 
 func TestWriteOverrun(t *testing.T) {
@@ -221,6 +222,41 @@ func TestPeekN(t *testing.T) {
 	testReadN(t, b, "hello", 0)
 }
 
+func TestSeen(t *testing.T) {
+	t1 := time.Unix(1, 0)
+	t2 := time.Unix(2, 0)
+	t3 := time.Unix(3, 0)
+	b := NewBuffer(128)
+	b.WriteSeen(0, []byte("hel"), t1)
+	b.WriteSeen(0, []byte("lo\nwor"), t2)
+	if !b.Seen().Equal(t1) {
+		t.Error(b.Seen(), t1)
+	}
+
+	testReadN(t, b, "he", 7)
+	if !b.Seen().Equal(t1) || !b.LastSeen().Equal(t1) {
+		t.Error(b.Seen(), b.LastSeen(), t1)
+	}
+
+	o, err := b.ReadLine()
+	if err != nil || !bytes.Equal(o, []byte("llo")) {
+		t.Error(string(o), err)
+	}
+	if !b.Seen().Equal(t2) || !b.LastSeen().Equal(t2) {
+		t.Error(b.Seen(), b.LastSeen(), t2)
+	}
+
+	// discard past the end of the buffered data
+	b.Discard(5)
+	if !b.Seen().IsZero() || !b.LastSeen().Equal(t2) {
+		t.Error(b.Seen(), b.LastSeen(), t2)
+	}
+	b.WriteSeen(0, []byte("ld"), t3)
+	if !b.Seen().IsZero() || !b.LastSeen().Equal(t3) {
+		t.Error(b.Seen(), b.LastSeen(), t3)
+	}
+}
+
 func testReadN(t *testing.T, b *Buffer, expect string, remain int) {
 	o, err := b.ReadN(len(expect))
 	if err != nil {
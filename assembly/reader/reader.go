@@ -2,6 +2,7 @@ package reader
 
 import (
 	"io"
+	"time"
 
 	"github.com/google/gopacket/tcpassembly"
 )
@@ -29,7 +30,7 @@ func (r *Reader) Reassembled(rs []tcpassembly.Reassembly) {
 		return
 	}
 	for _, reassembly := range rs {
-		err := r.buf.Write(reassembly.Skip, reassembly.Bytes)
+		err := r.buf.WriteSeen(reassembly.Skip, reassembly.Bytes, reassembly.Seen)
 		if err != nil {
 			r.err = err
 			return
@@ -52,6 +53,11 @@ func (r *Reader) Truncate() {
 	r.buf.Truncate()
 }
 
+// Len returns the number of bytes buffered, including any lost to gaps.
+func (r *Reader) Len() int {
+	return r.buf.Len()
+}
+
 func (r *Reader) Discard(n int) (discarded int, err error) {
 	if r.err != nil {
 		return 0, r.err
@@ -116,6 +122,23 @@ func (r *Reader) ReadLine() (out []byte, err error) {
 	return
 }
 
+// Seen returns the capture time of the next unread byte, or the zero time if
+// no data is available.
+func (r *Reader) Seen() time.Time {
+	return r.buf.Seen()
+}
+
+// LastSeen returns the capture time of the most recently consumed byte.
+func (r *Reader) LastSeen() time.Time {
+	return r.buf.LastSeen()
+}
+
+// Discarding returns the number of bytes yet to arrive that will be
+// discarded, for values skipped before they were captured.
+func (r *Reader) Discarding() int {
+	return r.buf.Discarding()
+}
+
 func (r *Reader) Close() error {
 	r.closed = true
 	r.buf.Reset()
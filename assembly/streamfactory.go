@@ -6,10 +6,7 @@ import (
 
 	"github.com/box/memsniff/analysis"
 	"github.com/box/memsniff/log"
-	"github.com/box/memsniff/protocol/infer"
-	"github.com/box/memsniff/protocol/mctext"
 	"github.com/box/memsniff/protocol/model"
-	"github.com/box/memsniff/protocol/redis"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/tcpassembly"
@@ -42,8 +39,12 @@ func (c *connectionKey) DstString() string {
 type streamFactory struct {
 	logger   log.Logger
 	analysis *analysis.Pool
-	protocol model.ProtocolType
+	protocol *model.Protocol
 	ports    []int
+	// portProtocols overrides protocol for connections to specific server ports.
+	portProtocols map[int]*model.Protocol
+	// opts configures the Fsm created for each connection.
+	opts model.Options
 
 	halfOpen map[connectionKey]*model.Consumer
 }
@@ -103,16 +104,17 @@ func (sf *streamFactory) New(netFlow, transportFlow gopacket.Flow) tcpassembly.S
 
 func (sf *streamFactory) createConsumer(ck connectionKey) *model.Consumer {
 	logger := log.NewContext(sf.logger, ck.DstString())
-	var fsm model.Fsm
-	switch sf.protocol {
-	case model.ProtocolInfer:
-		fsm = infer.NewFsm(logger)
-	case model.ProtocolMemcacheText:
-		fsm = mctext.NewFsm(logger)
-	case model.ProtocolRedis:
-		fsm = redis.NewFsm(logger)
+	protocol := sf.protocol
+	if p, ok := sf.portProtocols[srcPort(ck.transportFlow)]; ok {
+		protocol = p
 	}
-	return model.New(sf.analysis.HandleEvents, fsm)
+	c := model.New(sf.analysis.HandleEvents, protocol.NewFsm(logger, sf.opts))
+	// ck is oriented from server to client
+	c.ServerAddr = ck.netFlow.Src().String()
+	c.ServerPort = srcPort(ck.transportFlow)
+	c.ClientAddr = ck.netFlow.Dst().String()
+	c.ClientPort = srcPort(ck.transportFlow.Reverse())
+	return c
 }
 
 func (sf *streamFactory) log(items ...interface{}) {
@@ -26,12 +26,14 @@ type worker struct {
 	wiCh      chan workItem
 }
 
-func newWorker(logger log.Logger, analysis *analysis.Pool, protocol model.ProtocolType, ports []int) worker {
+func newWorker(logger log.Logger, analysis *analysis.Pool, protocol *model.Protocol, ports []int, portProtocols map[int]*model.Protocol, opts model.Options) worker {
 	sf := streamFactory{
-		logger:   logger,
-		analysis: analysis,
-		protocol: protocol,
-		ports:    ports,
+		logger:        logger,
+		analysis:      analysis,
+		protocol:      protocol,
+		ports:         ports,
+		portProtocols: portProtocols,
+		opts:          opts,
 
 		halfOpen: make(map[connectionKey]*model.Consumer),
 	}
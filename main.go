@@ -4,9 +4,10 @@ package main
 
 import (
 	"fmt"
-	"github.com/box/memsniff/protocol/model"
 	"math"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/box/memsniff/analysis"
@@ -15,6 +16,11 @@ import (
 	"github.com/box/memsniff/decode"
 	"github.com/box/memsniff/log"
 	"github.com/box/memsniff/presentation"
+	_ "github.com/box/memsniff/protocol/infer"
+	_ "github.com/box/memsniff/protocol/mcbinary"
+	_ "github.com/box/memsniff/protocol/mctext"
+	"github.com/box/memsniff/protocol/model"
+	_ "github.com/box/memsniff/protocol/redis"
 	flag "github.com/spf13/pflag"
 )
 
@@ -22,8 +28,9 @@ var (
 	netInterface = flag.StringP("interface", "i", "", "network interface to sniff")
 	infile       = flag.StringP("read", "r", "", "file to read (- for stdin)")
 	bufferSize   = flag.IntP("buffersize", "b", 8, "MiB of kernel buffer for packet data")
-	protocol     = flag.StringP("protocol", "P", "infer", "datastore protocol (one of mctext, redis, or infer to guess based on content)")
-	ports        = flag.IntSliceP("ports", "p", []int{6379, 11211}, "ports to listen on")
+	protocol     = flag.StringP("protocol", "P", "infer", "datastore protocol for ports without one (one of "+strings.Join(model.ProtocolNames(), ", ")+"; infer guesses based on content)")
+	mcrouter     = flag.Bool("mcrouter", false, "split mcrouter routing prefixes such as /region/cluster/ from memcached text protocol keys into the route field, and report __mcrouter__ keys as introspect commands")
+	ports        = flag.StringSliceP("ports", "p", defaultPorts(), "ports to listen on, optionally with the protocol for each port (e.g. 11211=mctext,6379=redis)")
 
 	assemblyWorkers = flag.Int("assemblyworkers", 8, "number of TCP assembly workers")
 	decodeWorkers   = flag.Int("decodeworkers", 8, "number of decode workers")
@@ -31,9 +38,14 @@ var (
 	profiles        = flag.StringSlice("profile", []string{}, "profile types to store (one or more of cpu, heap, block)")
 
 	filter     = flag.String("filter", "", "regex pattern of cache keys to track")
-	format     = flag.StringP("format", "f", "key,max(size),sum(size)", "fields (key, size) and aggregates (avg, max, min, sum, p50 (median), p995 (99.5th percentile), etc.) to display")
+	commands   = flag.StringSlice("commands", []string{}, "operations to track (one or more of get, set, delete, etc.), default all")
+	keyRules   = flag.StringSlice("keyrules", analysis.DefaultKeyRules, "rules to derive keypattern from keys, applied in order (uuid, hex, digits, or regex=placeholder)")
+	format     = flag.StringP("format", "f", "key,max(size),sum(size),rate()", "fields (key, size, cmd, latency in microseconds, client, clientport, server, db, user, script, slot, target, error, route, keypattern) and aggregates (avg, max, min, sum, stddev, p50 (median), p995 (99.5th percentile), distinct (estimated unique values of any field), etc.) to display; count, rate (per second), erate (rate decayed over each window), hits, misses and hitratio (percent) need no field")
+	sortBy     = flag.String("sort", "", "field or aggregate to sort by, prefixed with + for ascending or - for descending order (default sum(size) if displayed)")
 	interval   = flag.IntP("interval", "n", 1, "report top keys every this many seconds")
+	approx     = flag.Int("approx", 0, "MiB of memory for tracking only the most active keys, reporting the maxerror of each row (0 to track all keys exactly)")
 	cumulative = flag.Bool("cumulative", false, "accumulate keys over all time instead of an interval")
+	windows    = flag.StringSlice("windows", []string{}, "rolling windows to report side by side instead of an interval (e.g. 1s,10s,60s)")
 
 	noDelay             = flag.Bool("nodelay", false, "replay from file at maximum speed instead of rate of original capture")
 	noGui               = flag.Bool("nogui", false, "disable interactive interface")
@@ -69,20 +81,51 @@ func main() {
 		log.ConsoleLogger{}.Log(err)
 		os.Exit(1)
 	}
+	if err = analysisPool.SetFilterCommands(*commands); err != nil {
+		log.ConsoleLogger{}.Log(err)
+		os.Exit(1)
+	}
+	if err = analysisPool.SetKeyRules(*keyRules); err != nil {
+		log.ConsoleLogger{}.Log(err)
+		os.Exit(1)
+	}
+	if err = analysisPool.SetMemoryBudget(*approx * 1024 * 1024); err != nil {
+		log.ConsoleLogger{}.Log(err)
+		os.Exit(1)
+	}
+	windowLengths, err := parseWindows(*windows)
+	if err != nil {
+		log.ConsoleLogger{}.Log(err)
+		os.Exit(1)
+	}
+	if err = analysisPool.SetWindows(windowLengths); err != nil {
+		log.ConsoleLogger{}.Log(err)
+		os.Exit(1)
+	}
+	if err = analysisPool.SetSortOrder(*sortBy); err != nil {
+		log.ConsoleLogger{}.Log(err)
+		os.Exit(1)
+	}
 
-	protocolType := model.GetProtocolType(*protocol)
-	if protocolType == model.ProtocolUnknown {
+	protocolType := model.LookupProtocol(*protocol)
+	if protocolType == nil {
 		log.ConsoleLogger{}.Log("unknown protocol: ", *protocol)
 		os.Exit(1)
 	}
 
-	packetSource, err := capture.New(*netInterface, *infile, *bufferSize, *noDelay, *ports)
+	portList, portProtocols, err := model.ParsePorts(*ports)
+	if err != nil {
+		log.ConsoleLogger{}.Log(err)
+		os.Exit(1)
+	}
+
+	packetSource, err := capture.New(*netInterface, *infile, *bufferSize, *noDelay, portList)
 	if err != nil {
 		log.ConsoleLogger{}.Log(err)
 		os.Exit(2)
 	}
 
-	decodePool := decode.NewPool(logger, *decodeWorkers, packetSource, packetHandler(protocolType, analysisPool))
+	decodePool := decode.NewPool(logger, *decodeWorkers, packetSource, packetHandler(protocolType, portList, portProtocols, analysisPool))
 	eofChan := make(chan struct{}, 1)
 	go func() {
 		decodePool.Run()
@@ -140,12 +183,16 @@ func statGenerator(captureProvider capture.StatProvider, decodePool *decode.Pool
 
 		incrementalStats = cumulativeStats.Diff(previousStats)
 
-		return presentation.StatsSet{&cumulativeStats, &incrementalStats}
+		return presentation.StatsSet{Culumative: &cumulativeStats, Incremental: &incrementalStats}
 	}
 }
 
-func packetHandler(protocol model.ProtocolType, analysisPool *analysis.Pool) func(dps []*decode.DecodedPacket) {
-	pool := assembly.New(logger, analysisPool, protocol, *ports, *assemblyWorkers)
+func packetHandler(protocol *model.Protocol, ports []int, portProtocols map[int]*model.Protocol, analysisPool *analysis.Pool) func(dps []*decode.DecodedPacket) {
+	opts := model.Options{
+		SplitRoutes: *mcrouter,
+		KeySlots:    analysisPool.UsesField(model.FieldSlot),
+	}
+	pool := assembly.New(logger, analysisPool, protocol, ports, portProtocols, opts, *assemblyWorkers)
 	return func(dps []*decode.DecodedPacket) {
 		err := pool.HandlePackets(dps)
 		if err != nil {
@@ -153,3 +200,25 @@ func packetHandler(protocol model.ProtocolType, analysisPool *analysis.Pool) fun
 		}
 	}
 }
+
+// defaultPorts returns the conventional ports of all registered protocols.
+func defaultPorts() []string {
+	var ports []string
+	for _, port := range model.DefaultPorts() {
+		ports = append(ports, strconv.Itoa(port))
+	}
+	return ports
+}
+
+// parseWindows parses durations such as "10s" given with --windows.
+func parseWindows(specs []string) ([]time.Duration, error) {
+	var lengths []time.Duration
+	for _, spec := range specs {
+		l, err := time.ParseDuration(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %v", spec, err)
+		}
+		lengths = append(lengths, l)
+	}
+	return lengths, nil
+}
@@ -29,16 +29,17 @@ func (u *uiContext) runLoggingEventLoop() error {
 
 func (u *uiContext) updateReport() error {
 	rep := u.analysis.Report(!u.cumulative)
-	rep.SortBy(-2)
+	rep.Sort()
 
 	numKeysSeen := len(rep.Rows)
-	totalBandwidthUsed := totalBytesUseForKeys(rep.Rows)
+	sizeCol := sumSizeColumn(rep)
+	totalBandwidthUsed := totalBytesUseForKeys(rep.Rows, sizeCol)
 
 	s := u.statProvider()
 
 	u.truncateResultsToMaxAndTopX(&rep)
 	u.prevReport = rep
-	reportedKeysBandwidth := totalBytesUseForKeys(rep.Rows)
+	reportedKeysBandwidth := totalBytesUseForKeys(rep.Rows, sizeCol)
 
 	var f *os.File = nil
 	if u.outputFile != "" {
@@ -71,6 +72,7 @@ func (u *uiContext) updateReport() error {
 type JsonReport struct {
 	Timestamp                   string
 	TotalKeys                   int
+	DistinctKeys                int64
 	TotalBandwidth              int64
 	ReportedKeys                int
 	ReportedBandwidth           int64
@@ -88,6 +90,7 @@ func formatReportAsJson(report analysis.Report, stats StatsSet, totalKeys int, t
 	return JsonReport{
 		Timestamp:                   report.Timestamp.Format(time.RFC3339),
 		TotalKeys:                   totalKeys,
+		DistinctKeys:                report.DistinctKeys,
 		TotalBandwidth:              totalBandwidth,
 		ReportedKeys:                len(report.Rows),
 		ReportedBandwidth:           reportedBandwidth,
@@ -102,6 +105,9 @@ func reportToList(report analysis.Report) []map[string]interface{} {
 	reportList := make([]map[string]interface{}, len(report.Rows))
 	for idx, row := range report.Rows {
 		reportList[idx] = reportRowToMap(row, report.KeyColNames, report.ValColNames)
+		if report.Approximate {
+			reportList[idx][maxErrorColName] = row.MaxError
+		}
 	}
 	return reportList
 }
@@ -120,15 +126,15 @@ func reportRowToMap(reportRow analysis.ReportRow, keyColNames []string, valueCol
 	return rowMap
 }
 
-// Given a slice of rows, return the sum of the sum(size) columns
-func totalBytesUseForKeys(rows []analysis.ReportRow) int64 {
+// Given a slice of rows, return the sum of their sum(size) values in column
+// col, or zero if there is no such column
+func totalBytesUseForKeys(rows []analysis.ReportRow, col int) int64 {
 	total := int64(0)
+	if col < 0 {
+		return total
+	}
 	for _, r := range rows {
-		// This code assumes that we are using the default format, same as the sorting
-		// code.  This should also be updated whenever we update the sorting code to not
-		// depend on the default format.
-		const maxSizeIndex = 1
-		total = total + r.Values[maxSizeIndex]
+		total = total + r.Values[col]
 	}
 	return total
 }
@@ -6,9 +6,14 @@ import (
 	"fmt"
 	"github.com/box/memsniff/analysis"
 	"github.com/box/memsniff/log"
+	"strings"
 	"time"
 )
 
+// maxErrorColName is the heading for the possible error of each row of an
+// approximate report.
+const maxErrorColName = "maxerror"
+
 // UIHandler is the external API for an interactive user interface.
 type UIHandler interface {
 	// Run starts this UIHandler running.
@@ -106,16 +111,32 @@ func (u uiContext) Log(items ...interface{}) {
 	u.msgChan <- fmt.Sprint(items...)
 }
 
+// truncateResultsToMaxAndTopX keeps the first topX rows of rep whose
+// sum(size) meets the threshold.  The threshold is ignored if sum(size) is
+// not reported.
 func (u *uiContext) truncateResultsToMaxAndTopX(rep *analysis.Report) {
-	repRows := rep.Rows
-	i := 0
-	for n, r := range repRows {
-		if r.Values[1] >= int64(u.minKeySizeThreshold) && n < int(u.topX) {
-			i++
-		} else {
+	col := sumSizeColumn(*rep)
+	rows := rep.Rows[:0]
+	for _, r := range rep.Rows {
+		if len(rows) >= int(u.topX) {
 			break
 		}
+		if col >= 0 && r.Values[col] < int64(u.minKeySizeThreshold) {
+			continue
+		}
+		rows = append(rows, r)
 	}
+	rep.Rows = rows
+}
 
-	rep.Rows = repRows[:(i)]
+// sumSizeColumn returns the index of the sum(size) value column of rep, or
+// of the shortest window's if reporting rolling windows, or -1 if there is
+// none.
+func sumSizeColumn(rep analysis.Report) int {
+	for i, name := range rep.ValColNames {
+		if name == "sum(size)" || strings.HasPrefix(name, "sum(size)/") {
+			return i
+		}
+	}
+	return -1
 }
@@ -0,0 +1,47 @@
+package presentation
+
+import (
+	"github.com/box/memsniff/analysis"
+	"testing"
+)
+
+func rows(values ...int64) []analysis.ReportRow {
+	var rows []analysis.ReportRow
+	for _, v := range values {
+		rows = append(rows, analysis.ReportRow{Key: []string{"key"}, Values: []int64{v}})
+	}
+	return rows
+}
+
+func TestTruncateWithoutSumSize(t *testing.T) {
+	u := &uiContext{topX: 2, minKeySizeThreshold: 100}
+	rep := analysis.Report{
+		KeyColNames: []string{"cmd", "key"},
+		ValColNames: []string{"count"},
+		Rows:        rows(1, 5, 3),
+	}
+	u.truncateResultsToMaxAndTopX(&rep)
+	if len(rep.Rows) != 2 {
+		t.Error(rep.Rows)
+	}
+	if total := totalBytesUseForKeys(rep.Rows, sumSizeColumn(rep)); total != 0 {
+		t.Error(total)
+	}
+}
+
+func TestTruncateBySumSize(t *testing.T) {
+	u := &uiContext{topX: 10, minKeySizeThreshold: 100}
+	rep := analysis.Report{
+		KeyColNames: []string{"key"},
+		ValColNames: []string{"sum(size)/1s"},
+		// sorted by another column, so sizes are in no particular order
+		Rows: rows(50, 200, 10, 100),
+	}
+	u.truncateResultsToMaxAndTopX(&rep)
+	if len(rep.Rows) != 2 || rep.Rows[0].Values[0] != 200 || rep.Rows[1].Values[0] != 100 {
+		t.Error(rep.Rows)
+	}
+	if total := totalBytesUseForKeys(rep.Rows, sumSizeColumn(rep)); total != 300 {
+		t.Error(total)
+	}
+}
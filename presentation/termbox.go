@@ -130,7 +130,12 @@ func renderHeader(rep analysis.Report) {
 		renderText(col, 0, h)
 		col++
 	}
+	if rep.Approximate {
+		renderText(col, 0, maxErrorColName)
+	}
 	renderLine(0, 12, 1, '-')
+	// set into the rule below the headings, which wide formats leave free
+	renderTextRight(1, fmt.Sprintf(" Distinct keys: %d ", rep.DistinctKeys))
 }
 
 func renderReport(rep analysis.Report) {
@@ -146,6 +151,9 @@ func renderReport(rep analysis.Report) {
 			renderText(col, y, strconv.Itoa(int(v)))
 			col++
 		}
+		if rep.Approximate {
+			renderText(col, y, strconv.Itoa(int(r.MaxError)))
+		}
 		y++
 		if y > lastY {
 			break
@@ -166,7 +174,7 @@ func (u *uiContext) renderFooter(rep analysis.Report) {
 
 	renderText(2, y, u.dropLabel(*stats.Incremental))
 	renderText(4, y, fmt.Sprintf("Packets: %10d", stats.Incremental.PacketsPassedFilter))
-	renderText(6, y, fmt.Sprintf("GET responses: %10d", stats.Incremental.ResponsesParsed))
+	renderText(6, y, fmt.Sprintf("Responses: %10d", stats.Incremental.ResponsesParsed))
 }
 
 func (u *uiContext) dropLabel(s Stats) string {
@@ -192,6 +200,16 @@ func renderText(column int, y int, txt string) {
 	}
 }
 
+// renderTextRight renders txt ending one cell before the right edge of row y.
+func renderTextRight(y int, txt string) {
+	w, _ := termbox.Size()
+	x := w - 1 - runewidth.StringWidth(txt)
+	for _, r := range txt {
+		termbox.SetCell(x, y, r, termbox.ColorDefault, termbox.ColorDefault)
+		x += runewidth.RuneWidth(r)
+	}
+}
+
 func renderLine(column int, span int, y int, ch rune) {
 	w := runewidth.RuneWidth(ch)
 	for x := columnX(column); x < columnX(column+span); x += w {
@@ -222,7 +240,7 @@ func (u *uiContext) update() error {
 	// so we don't get a big burst of data on unpause.
 	rep := u.analysis.Report(!u.cumulative)
 	if !u.paused {
-		rep.SortBy(-2)
+		rep.Sort()
 		u.truncateResultsToMaxAndTopX(&rep)
 		u.prevReport = rep
 	}
@@ -1,20 +1,38 @@
 package infer
 
 import (
+	"github.com/box/memsniff/assembly/reader"
 	"github.com/box/memsniff/log"
-	"github.com/box/memsniff/protocol/mctext"
 	"github.com/box/memsniff/protocol/model"
-	"github.com/box/memsniff/protocol/redis"
 )
 
+const (
+	// maxUndecided is the amount of client data to buffer while waiting for a
+	// server reply to identify the protocol, before settling for the most
+	// likely candidate.
+	maxUndecided = 4096
+	// sniffLen is the amount of data from each side offered to the sniff
+	// function of each protocol.
+	sniffLen = 64
+)
+
+func init() {
+	model.Register(model.Protocol{
+		Name:   "infer",
+		NewFsm: NewFsm,
+	})
+}
+
 // fsm guesses and redirects to a protocol-correct consumer.
 type fsm struct {
 	logger   log.Logger
 	consumer *model.Consumer
+	// opts is passed on to the Fsm of the inferred protocol.
+	opts model.Options
 }
 
-func NewFsm(logger log.Logger) model.Fsm {
-	return &fsm{logger: logger}
+func NewFsm(logger log.Logger, opts model.Options) model.Fsm {
+	return &fsm{logger: logger, opts: opts}
 }
 
 func (f *fsm) SetConsumer(consumer *model.Consumer) {
@@ -22,18 +40,74 @@ func (f *fsm) SetConsumer(consumer *model.Consumer) {
 }
 
 func (f *fsm) Run() {
-	out, err := f.consumer.ClientReader.PeekN(1)
-	if err != nil {
+	protocol := f.infer()
+	if protocol == nil {
 		return
 	}
-	var fsm model.Fsm
-	switch out[0] {
-	case '*':
-		fsm = redis.NewFsm(f.logger)
-	default:
-		fsm = mctext.NewFsm(f.logger)
-	}
+	fsm := protocol.NewFsm(f.logger, f.opts)
 	fsm.SetConsumer(f.consumer)
 	f.consumer.Fsm = fsm
 	fsm.Run()
 }
+
+// infer returns the protocol in use, or nil if there is not yet enough data
+// to decide.
+//
+// Each registered protocol scores the data seen so far.  A conclusive score
+// decides immediately.  Otherwise the best candidate served on the server
+// port is chosen, or failing that the best candidate overall once the server
+// has replied or too much client data is buffered.  Ties go to the protocol
+// whose name sorts first.
+func (f *fsm) infer() *model.Protocol {
+	client := peek(f.consumer.ClientReader)
+	if len(client) == 0 {
+		return nil
+	}
+	server := peek(f.consumer.ServerReader)
+
+	var best, bestOnPort *model.Protocol
+	var bestScore, bestOnPortScore float64
+	for _, p := range model.Protocols() {
+		if p.Sniff == nil {
+			continue
+		}
+		score := p.Sniff(client, server)
+		if score >= 1 {
+			return p
+		}
+		if score > bestScore {
+			best, bestScore = p, score
+		}
+		if score > bestOnPortScore && isInPortlist(p.Ports, f.consumer.ServerPort) {
+			bestOnPort, bestOnPortScore = p, score
+		}
+	}
+	if bestOnPort != nil {
+		return bestOnPort
+	}
+	// Stop waiting if the server has replied, or may never reply as with
+	// memcached noreply commands.
+	if len(server) > 0 || f.consumer.ClientReader.Len() >= maxUndecided {
+		return best
+	}
+	return nil
+}
+
+// peek returns up to sniffLen bytes buffered in r without consuming them.
+func peek(r *reader.Reader) []byte {
+	n := r.Len()
+	if n > sniffLen {
+		n = sniffLen
+	}
+	out, _ := r.PeekN(n)
+	return out
+}
+
+func isInPortlist(ports []int, port int) bool {
+	for _, p := range ports {
+		if port == p {
+			return true
+		}
+	}
+	return false
+}
@@ -4,7 +4,10 @@ import (
 	"testing"
 
 	"github.com/box/memsniff/log"
+	_ "github.com/box/memsniff/protocol/mcbinary"
+	_ "github.com/box/memsniff/protocol/mctext"
 	"github.com/box/memsniff/protocol/model"
+	_ "github.com/box/memsniff/protocol/redis"
 	"github.com/google/gopacket/tcpassembly"
 )
 
@@ -49,6 +52,51 @@ func TestInferMemcached(t *testing.T) {
 	test(t, input, output, expected)
 }
 
+func TestInferRedisInline(t *testing.T) {
+	input := []string{
+		"PING",
+		"GET foo",
+	}
+	output := []string{
+		"+PONG",
+		"$3",
+		"bar",
+	}
+	expected := []model.Event{
+		{
+			Type: model.EventGetHit,
+			Key:  "foo",
+			Size: 3,
+		},
+	}
+	test(t, input, output, expected)
+}
+
+func TestInferRedisPort(t *testing.T) {
+	c := model.New(func(evts []model.Event) {}, NewFsm(&log.ConsoleLogger{}, model.Options{}))
+	c.ServerPort = 6379
+	c.ClientStream().Reassembled(reassemblyString("PING\r\n"))
+	if _, ok := c.Fsm.(*fsm); ok {
+		t.Error("did not infer protocol from server port")
+	}
+}
+
+func TestInferWaitsForReply(t *testing.T) {
+	c := model.New(func(evts []model.Event) {}, NewFsm(&log.ConsoleLogger{}, model.Options{}))
+	c.ClientStream().Reassembled(reassemblyString("get foo\r\n"))
+	if _, ok := c.Fsm.(*fsm); !ok {
+		t.Error("inferred protocol without a server reply or known port")
+	}
+}
+
+func TestInferMemcachedBinary(t *testing.T) {
+	c := model.New(func(evts []model.Event) {}, NewFsm(&log.ConsoleLogger{}, model.Options{}))
+	c.ClientStream().Reassembled(reassemblyString("\x80\x00\x00\x00"))
+	if _, ok := c.Fsm.(*fsm); ok {
+		t.Error("did not infer protocol from binary magic byte")
+	}
+}
+
 func reassemblyString(s string) []tcpassembly.Reassembly {
 	return []tcpassembly.Reassembly{{Bytes: []byte(s)}}
 }
@@ -62,7 +110,7 @@ func test(t *testing.T, input []string, output []string, expected []model.Event)
 			expected = expected[1:]
 		}
 	}
-	c := model.New(handler, NewFsm(&log.ConsoleLogger{}))
+	c := model.New(handler, NewFsm(&log.ConsoleLogger{}, model.Options{}))
 	for _, s := range input {
 		c.ClientStream().Reassembled(reassemblyString(s))
 		c.ClientStream().Reassembled(reassemblyString("\r\n"))
@@ -78,3 +126,26 @@ func test(t *testing.T, input []string, output []string, expected []model.Event)
 		t.Error("Expected", expected, "events but never received")
 	}
 }
+
+type proxyFsm struct{}
+
+func (f *proxyFsm) SetConsumer(*model.Consumer) {}
+func (f *proxyFsm) Run()                        {}
+
+func TestInferRegisteredProtocol(t *testing.T) {
+	model.Register(model.Protocol{
+		Name:   "testproxy",
+		NewFsm: func(log.Logger, model.Options) model.Fsm { return &proxyFsm{} },
+		Sniff: func(client, server []byte) float64 {
+			if len(client) >= 4 && string(client[:4]) == "PRXY" {
+				return 1
+			}
+			return 0
+		},
+	})
+	c := model.New(func(evts []model.Event) {}, NewFsm(&log.ConsoleLogger{}, model.Options{}))
+	c.ClientStream().Reassembled(reassemblyString("PRXY\x01\x02"))
+	if _, ok := c.Fsm.(*proxyFsm); !ok {
+		t.Error("did not infer registered protocol")
+	}
+}
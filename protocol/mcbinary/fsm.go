@@ -0,0 +1,409 @@
+package mcbinary
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/box/memsniff/assembly/reader"
+	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/protocol/model"
+)
+
+const (
+	headerSize    = 24
+	magicRequest  = 0x80
+	magicResponse = 0x81
+	debuglevel    = 0
+
+	// maxPending limits the number of requests awaiting a response, to bound
+	// memory used by connections where responses are lost.
+	maxPending = 1024
+)
+
+const (
+	opGet   opcode = 0x00
+	opGetQ  opcode = 0x09
+	opNoop  opcode = 0x0a
+	opGetK  opcode = 0x0c
+	opGetKQ opcode = 0x0d
+)
+
+const (
+	statusNoError     = 0x0000
+	statusKeyNotFound = 0x0001
+)
+
+var (
+	errBadMagic = errors.New("bad magic byte in binary protocol header")
+	// errBadLength is returned when the body of a packet is too short to
+	// hold the extras and key given in its header.
+	errBadLength = errors.New("body length shorter than extras and key in binary protocol header")
+	// errUnmatchedResponse is returned when a response does not match any
+	// pending request, meaning requests were lost and later responses cannot
+	// be matched either.
+	errUnmatchedResponse = errors.New("response did not match any pending request")
+
+	// opcodeNames holds the names of common opcodes.
+	opcodeNames = map[opcode]string{
+		0x00: "get",
+		0x01: "set",
+		0x02: "add",
+		0x03: "replace",
+		0x04: "delete",
+		0x05: "increment",
+		0x06: "decrement",
+		0x08: "flush",
+		0x09: "getq",
+		0x0c: "getk",
+		0x0d: "getkq",
+		0x0e: "append",
+		0x0f: "prepend",
+		0x1c: "touch",
+		0x1d: "gat",
+	}
+
+	// opcodeEvents maps opcodes, including quiet variants, to the type of
+	// event produced by the operation.
+	opcodeEvents = map[opcode]model.EventType{
+		0x00: model.EventGetHit,
+		0x01: model.EventSet,
+		0x02: model.EventAdd,
+		0x03: model.EventReplace,
+		0x04: model.EventDelete,
+		0x05: model.EventIncr,
+		0x06: model.EventDecr,
+		0x08: model.EventFlush,
+		0x09: model.EventGetHit,
+		0x0c: model.EventGetHit,
+		0x0d: model.EventGetHit,
+		0x0e: model.EventAppend,
+		0x0f: model.EventPrepend,
+		0x11: model.EventSet,
+		0x12: model.EventAdd,
+		0x13: model.EventReplace,
+		0x14: model.EventDelete,
+		0x15: model.EventIncr,
+		0x16: model.EventDecr,
+		0x18: model.EventFlush,
+		0x19: model.EventAppend,
+		0x1a: model.EventPrepend,
+		0x1c: model.EventTouch,
+		0x1d: model.EventGatHit,
+		0x1e: model.EventGatHit,
+		0x23: model.EventGatHit,
+		0x24: model.EventGatHit,
+	}
+
+	// statusErrors maps response statuses that indicate an error to the
+	// class of error.  Statuses such as key not found are results rather
+	// than errors.
+	statusErrors = map[uint16]string{
+		0x0003: "VALUE_TOO_LARGE",
+		0x0004: "INVALID_ARGUMENTS",
+		0x0006: "NON_NUMERIC",
+		0x0007: "WRONG_VBUCKET",
+		0x0020: "AUTH_ERROR",
+		0x0081: "UNKNOWN_COMMAND",
+		0x0082: "OUT_OF_MEMORY",
+		0x0083: "NOT_SUPPORTED",
+		0x0084: "INTERNAL_ERROR",
+		0x0085: "BUSY",
+		0x0086: "TEMPORARY_FAILURE",
+	}
+)
+
+// opcode identifies the command in a binary protocol packet.
+type opcode uint8
+
+func (op opcode) String() string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", uint8(op))
+}
+
+// command returns the name of the operation performed by op, as returned by
+// EventType.Command, or the empty string if unknown.
+func (op opcode) command() string {
+	if evtType, ok := opcodeEvents[op]; ok {
+		return evtType.Command()
+	}
+	return ""
+}
+
+// isGet returns true if op is one of the GET family of commands.
+func (op opcode) isGet() bool {
+	switch op {
+	case opGet, opGetQ, opGetK, opGetKQ:
+		return true
+	default:
+		return false
+	}
+}
+
+// isQuiet returns true if the server only responds to op when there is
+// something interesting to report, e.g. a hit for GETQ.
+func (op opcode) isQuiet() bool {
+	switch op {
+	case opGetQ, opGetKQ:
+		return true
+	case 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1e, 0x24:
+		// SETQ, ADDQ, REPLACEQ, DELETEQ, INCREMENTQ, DECREMENTQ, QUITQ,
+		// FLUSHQ, APPENDQ, PREPENDQ, GATQ, GATKQ
+		return true
+	default:
+		return false
+	}
+}
+
+// header is the fixed-size header common to binary protocol requests and responses.
+type header struct {
+	magic     uint8
+	opcode    opcode
+	keyLen    int
+	extrasLen int
+	// status is the response status, or the vbucket ID in a request.
+	status  uint16
+	bodyLen int
+	opaque  uint32
+}
+
+func parseHeader(b []byte) header {
+	return header{
+		magic:     b[0],
+		opcode:    opcode(b[1]),
+		keyLen:    int(binary.BigEndian.Uint16(b[2:4])),
+		extrasLen: int(b[4]),
+		status:    binary.BigEndian.Uint16(b[6:8]),
+		bodyLen:   int(binary.BigEndian.Uint32(b[8:12])),
+		opaque:    binary.BigEndian.Uint32(b[12:16]),
+	}
+}
+
+// request is a client command awaiting a response from the server.
+type request struct {
+	opcode opcode
+	opaque uint32
+	key    string
+	// start is the capture time of the first byte of the request.
+	start time.Time
+}
+
+// fsm generates events based on a memcached binary protocol conversation.
+type fsm struct {
+	logger   log.Logger
+	consumer *model.Consumer
+	state    state
+	pending  []request
+}
+
+type state func() error
+
+func init() {
+	model.Register(model.Protocol{
+		Name:   "mcbinary",
+		NewFsm: func(logger log.Logger, _ model.Options) model.Fsm { return NewFsm(logger) },
+		Ports:  []int{11211},
+		Sniff:  sniff,
+	})
+}
+
+// sniff recognizes the magic byte that starts every binary protocol packet.
+func sniff(client, server []byte) float64 {
+	if len(client) > 0 && client[0] == magicRequest {
+		return 1
+	}
+	if len(server) > 0 && server[0] == magicResponse {
+		return 1
+	}
+	return 0
+}
+
+func NewFsm(logger log.Logger) model.Fsm {
+	fsm := &fsm{
+		logger: logger,
+	}
+	fsm.state = fsm.readRequest
+	return fsm
+}
+
+func (f *fsm) SetConsumer(consumer *model.Consumer) {
+	f.consumer = consumer
+}
+
+func (f *fsm) Run() {
+	for {
+		err := f.state()
+		switch err {
+		case nil:
+			continue
+		case reader.ErrShortRead, io.EOF:
+			return
+		default:
+			// data lost or protocol error, try to resync at the next request
+			f.log(2, "trying to resync after error:", err)
+			f.consumer.ClientReader.Reset()
+			f.consumer.ServerReader.Reset()
+			f.pending = f.pending[:0]
+			f.state = f.readRequest
+			return
+		}
+	}
+}
+
+func (f *fsm) readRequest() error {
+	f.log(3, "reading request header")
+	start := f.consumer.ClientReader.Seen()
+	buf, err := f.consumer.ClientReader.ReadN(headerSize)
+	if err != nil {
+		return err
+	}
+	hdr := parseHeader(buf)
+	if hdr.magic != magicRequest {
+		return errBadMagic
+	}
+	if hdr.bodyLen < hdr.extrasLen+hdr.keyLen {
+		return errBadLength
+	}
+	f.log(3, "read request header:", hdr)
+	_, err = f.consumer.ClientReader.Discard(hdr.extrasLen)
+	if err != nil {
+		return err
+	}
+	f.state = f.readRequestKey(hdr, start)
+	return nil
+}
+
+func (f *fsm) readRequestKey(hdr header, start time.Time) state {
+	return func() error {
+		key, err := f.consumer.ClientReader.ReadN(hdr.keyLen)
+		if err != nil {
+			return err
+		}
+		f.addPending(request{
+			opcode: hdr.opcode,
+			opaque: hdr.opaque,
+			key:    string(key),
+			start:  start,
+		})
+		_, err = f.consumer.ClientReader.Discard(hdr.bodyLen - hdr.extrasLen - hdr.keyLen)
+		if err != nil {
+			return err
+		}
+		if hdr.opcode.isQuiet() {
+			// no response unless something interesting happens, keep reading
+			// pipelined requests
+			f.state = f.readRequest
+		} else {
+			f.state = f.readResponse
+		}
+		return nil
+	}
+}
+
+func (f *fsm) addPending(req request) {
+	if len(f.pending) >= maxPending {
+		f.log(2, "too many pending requests, discarding", f.pending[0])
+		f.pending = f.pending[1:]
+	}
+	f.pending = append(f.pending, req)
+}
+
+func (f *fsm) readResponse() error {
+	f.log(3, "reading response header")
+	buf, err := f.consumer.ServerReader.ReadN(headerSize)
+	if err != nil {
+		return err
+	}
+	hdr := parseHeader(buf)
+	if hdr.magic != magicResponse {
+		return errBadMagic
+	}
+	if hdr.bodyLen < hdr.extrasLen+hdr.keyLen {
+		return errBadLength
+	}
+	f.log(3, "read response header:", hdr)
+	_, err = f.consumer.ServerReader.Discard(hdr.bodyLen)
+	if err != nil {
+		return err
+	}
+
+	req, ok := f.matchPending(hdr)
+	if !ok {
+		f.log(2, "unmatched response:", hdr)
+		return errUnmatchedResponse
+	}
+	if class, isErr := statusErrors[hdr.status]; isErr {
+		f.addEvent(req, model.Event{
+			Type:    model.EventError,
+			Key:     req.key,
+			Error:   class,
+			Command: req.opcode.command(),
+		})
+	} else if req.opcode.isGet() {
+		f.handleGet(req, hdr)
+	}
+	if !req.opcode.isQuiet() {
+		f.state = f.readRequest
+	}
+	return nil
+}
+
+// matchPending finds the request to which the server is responding.
+//
+// Responses arrive in the same order as requests, but quiet requests may not
+// receive a response at all.  Any quiet requests that precede the matching
+// request have therefore completed silently and are removed.
+func (f *fsm) matchPending(hdr header) (request, bool) {
+	for i, req := range f.pending {
+		if req.opcode == hdr.opcode && req.opaque == hdr.opaque {
+			for _, skipped := range f.pending[:i] {
+				f.handleSilent(skipped)
+			}
+			f.pending = f.pending[i+1:]
+			return req, true
+		}
+	}
+	return request{}, false
+}
+
+// handleSilent records the outcome of a quiet request that received no response.
+func (f *fsm) handleSilent(req request) {
+	if req.opcode.isGet() {
+		f.addEvent(req, model.Event{
+			Type: model.EventGetMiss,
+			Key:  req.key,
+		})
+	}
+}
+
+func (f *fsm) handleGet(req request, hdr header) {
+	switch hdr.status {
+	case statusNoError:
+		f.addEvent(req, model.Event{
+			Type: model.EventGetHit,
+			Key:  req.key,
+			Size: hdr.bodyLen - hdr.extrasLen - hdr.keyLen,
+		})
+	case statusKeyNotFound:
+		f.addEvent(req, model.Event{
+			Type: model.EventGetMiss,
+			Key:  req.key,
+		})
+	}
+}
+
+// addEvent records evt as a result of req, with latency measured up to the
+// end of the response.
+func (f *fsm) addEvent(req request, evt model.Event) {
+	f.consumer.AddResponseEvent(evt, req.start)
+}
+
+func (f *fsm) log(level int, items ...interface{}) {
+	if f.logger != nil && debuglevel >= level {
+		f.logger.Log(items...)
+	}
+}
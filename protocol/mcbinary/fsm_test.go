@@ -0,0 +1,157 @@
+package mcbinary
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/protocol/model"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+func TestGetHit(t *testing.T) {
+	input := [][]byte{
+		packet(magicRequest, opGet, 0, 1, "key1", 0, 0),
+	}
+	output := [][]byte{
+		packet(magicResponse, opGet, statusNoError, 1, "", 4, 5),
+	}
+	test(t, input, output, []model.Event{
+		{Type: model.EventGetHit, Key: "key1", Size: 5},
+	})
+}
+
+func TestGetMiss(t *testing.T) {
+	input := [][]byte{
+		packet(magicRequest, opGetK, 0, 1, "key1", 0, 0),
+	}
+	output := [][]byte{
+		packet(magicResponse, opGetK, statusKeyNotFound, 1, "key1", 0, 9),
+	}
+	test(t, input, output, []model.Event{
+		{Type: model.EventGetMiss, Key: "key1"},
+	})
+}
+
+func TestQuietPipeline(t *testing.T) {
+	input := [][]byte{
+		packet(magicRequest, opGetKQ, 0, 1, "key1", 0, 0),
+		packet(magicRequest, opGetKQ, 0, 2, "key2", 0, 0),
+		packet(magicRequest, opGetKQ, 0, 3, "key3", 0, 0),
+		packet(magicRequest, opNoop, 0, 4, "", 0, 0),
+	}
+	output := [][]byte{
+		packet(magicResponse, opGetKQ, statusNoError, 2, "key2", 4, 10),
+		packet(magicResponse, opNoop, statusNoError, 4, "", 0, 0),
+	}
+	test(t, input, output, []model.Event{
+		{Type: model.EventGetMiss, Key: "key1"},
+		{Type: model.EventGetHit, Key: "key2", Size: 10},
+		{Type: model.EventGetMiss, Key: "key3"},
+	})
+}
+
+func TestIgnoreOtherCommands(t *testing.T) {
+	input := [][]byte{
+		// SET key1 with 8 bytes of extras and a 3 byte value
+		packet(magicRequest, 0x01, 0, 1, "key1", 8, 3),
+		packet(magicRequest, opGet, 0, 2, "key2", 0, 0),
+	}
+	output := [][]byte{
+		packet(magicResponse, 0x01, statusNoError, 1, "", 0, 0),
+		packet(magicResponse, opGet, statusNoError, 2, "", 4, 7),
+	}
+	test(t, input, output, []model.Event{
+		{Type: model.EventGetHit, Key: "key2", Size: 7},
+	})
+}
+
+func TestErrors(t *testing.T) {
+	input := [][]byte{
+		packet(magicRequest, opGet, 0, 1, "key1", 0, 0),
+		packet(magicRequest, 0x01, 0, 2, "key2", 8, 3),
+	}
+	output := [][]byte{
+		packet(magicResponse, opGet, 0x0084, 1, "", 0, 0),
+		packet(magicResponse, 0x01, 0x0082, 2, "", 0, 0),
+	}
+	test(t, input, output, []model.Event{
+		{Type: model.EventError, Key: "key1", Error: "INTERNAL_ERROR", Command: "get"},
+		{Type: model.EventError, Key: "key2", Error: "OUT_OF_MEMORY", Command: "set"},
+	})
+}
+
+func TestBadMagic(t *testing.T) {
+	input := [][]byte{
+		[]byte("get key1\r\n\r\n\r\n\r\n\r\n\r\n\r\n"),
+		packet(magicRequest, opGet, 0, 1, "key1", 0, 0),
+	}
+	test(t, input, nil, nil)
+}
+
+func TestBadLength(t *testing.T) {
+	input := [][]byte{
+		packet(magicRequest, opGet, 0, 1, "key1", 0, 0),
+	}
+	// claim a body too short for the key
+	binary.BigEndian.PutUint32(input[0][8:12], 2)
+	test(t, input, nil, nil)
+}
+
+func TestResyncAfterUnmatchedResponse(t *testing.T) {
+	var evts []model.Event
+	c := model.New(func(e []model.Event) { evts = append(evts, e...) }, NewFsm(&log.ConsoleLogger{}))
+	c.ClientStream().Reassembled([]tcpassembly.Reassembly{{Bytes: packet(magicRequest, opGet, 0, 1, "key1", 0, 0)}})
+	c.ServerStream().Reassembled([]tcpassembly.Reassembly{{Bytes: packet(magicResponse, opGet, statusNoError, 9, "", 4, 5)}})
+	c.ClientStream().Reassembled([]tcpassembly.Reassembly{{Bytes: packet(magicRequest, opGet, 0, 2, "key2", 0, 0)}})
+	c.ServerStream().Reassembled([]tcpassembly.Reassembly{{Bytes: packet(magicResponse, opGet, statusNoError, 2, "", 4, 7)}})
+	c.FlushEvents()
+
+	expected := []model.Event{{Type: model.EventGetHit, Key: "key2", Size: 7}}
+	if len(evts) != len(expected) || evts[0] != expected[0] {
+		t.Error(evts)
+	}
+}
+
+// packet builds a binary protocol packet with zero-filled extras and value.
+func packet(magic uint8, op opcode, status uint16, opaque uint32, key string, extrasLen int, valueLen int) []byte {
+	bodyLen := extrasLen + len(key) + valueLen
+	buf := make([]byte, headerSize+bodyLen)
+	buf[0] = magic
+	buf[1] = byte(op)
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(key)))
+	buf[4] = byte(extrasLen)
+	binary.BigEndian.PutUint16(buf[6:8], status)
+	binary.BigEndian.PutUint32(buf[8:12], uint32(bodyLen))
+	binary.BigEndian.PutUint32(buf[12:16], opaque)
+	copy(buf[headerSize+extrasLen:], key)
+	return buf
+}
+
+func test(t *testing.T, input [][]byte, output [][]byte, expected []model.Event) {
+	handler := func(evts []model.Event) {
+		for _, e := range evts {
+			if len(expected) == 0 {
+				t.Error("Unexpected event", e)
+				continue
+			}
+			if e != expected[0] {
+				t.Error("Expected", expected[0], "got", e)
+			}
+			expected = expected[1:]
+		}
+	}
+	c := model.New(handler, NewFsm(&log.ConsoleLogger{}))
+	for _, b := range input {
+		c.ClientStream().Reassembled([]tcpassembly.Reassembly{{Bytes: b}})
+	}
+	for _, b := range output {
+		c.ServerStream().Reassembled([]tcpassembly.Reassembly{{Bytes: b}})
+	}
+	c.ClientStream().ReassemblyComplete()
+	c.ServerStream().ReassemblyComplete()
+
+	if len(expected) > 0 {
+		t.Error("Expected", expected, "events but never received")
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"io"
 	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/box/memsniff/assembly/reader"
 	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/protocol/mcbinary"
 	"github.com/box/memsniff/protocol/model"
 )
 
@@ -18,8 +20,43 @@ const (
 )
 
 var (
-	asciiRe, _        = regexp.Compile(`^[a-zA-Z]+$`)
+	asciiRe, _        = regexp.Compile(`^[a-zA-Z_]+$`)
 	errProtocolDesync = errors.New("protocol desync while reading command")
+
+	// storageEvents maps storage commands to the type of event they produce.
+	storageEvents = map[string]model.EventType{
+		"set":     model.EventSet,
+		"add":     model.EventAdd,
+		"replace": model.EventReplace,
+		"append":  model.EventAppend,
+		"prepend": model.EventPrepend,
+		"cas":     model.EventCas,
+	}
+
+	// commandEvents maps other commands to the type of event they produce.
+	commandEvents = map[string]model.EventType{
+		"get":       model.EventGetHit,
+		"gets":      model.EventGetHit,
+		"gat":       model.EventGatHit,
+		"gats":      model.EventGatHit,
+		"delete":    model.EventDelete,
+		"incr":      model.EventIncr,
+		"decr":      model.EventDecr,
+		"touch":     model.EventTouch,
+		"flush_all": model.EventFlush,
+		"mg":        model.EventGetHit,
+	}
+
+	// outcomes maps single line server responses to the outcome they represent.
+	outcomes = map[string]model.Outcome{
+		"OK":         model.OutcomeOK,
+		"STORED":     model.OutcomeStored,
+		"NOT_STORED": model.OutcomeNotStored,
+		"EXISTS":     model.OutcomeExists,
+		"NOT_FOUND":  model.OutcomeNotFound,
+		"DELETED":    model.OutcomeDeleted,
+		"TOUCHED":    model.OutcomeTouched,
+	}
 )
 
 // fsm generates events based on a memcached text protocol conversation.
@@ -29,11 +66,56 @@ type fsm struct {
 	state    state
 	cmd      string
 	args     []string
+	// start is the capture time of the first byte of the current command.
+	start time.Time
+	// found counts the keys returned so far in response to a retrieval command.
+	found map[string]int
+	// pending holds meta commands still awaiting a response.
+	pending []metaRequest
+	// routes is set when talking to mcrouter, to split routing prefixes from
+	// keys and recognize introspection commands.  See model.Options.
+	routes bool
 }
 
 type state func() error
 
-func NewFsm(logger log.Logger) model.Fsm {
+func init() {
+	model.Register(model.Protocol{
+		Name:   "mctext",
+		NewFsm: NewFsm,
+		Ports:  []int{11211},
+		Sniff:  sniff,
+	})
+}
+
+// sniff recognizes text commands from the client.  Redis inline commands
+// look the same, so the decision is left to the server reply if there is one:
+// every memcached reply starts with a letter or digit, while RESP replies
+// start with punctuation.
+func sniff(client, server []byte) float64 {
+	if len(client) == 0 || !isAlpha(client[0]) {
+		return 0
+	}
+	if len(server) == 0 {
+		return 0.5
+	}
+	if isAlpha(server[0]) || ('0' <= server[0] && server[0] <= '9') {
+		return 1
+	}
+	return 0
+}
+
+func isAlpha(b byte) bool {
+	return ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+func NewFsm(logger log.Logger, opts model.Options) model.Fsm {
+	fsm := newFsm(logger)
+	fsm.routes = opts.SplitRoutes
+	return fsm
+}
+
+func newFsm(logger log.Logger) *fsm {
 	fsm := &fsm{
 		logger: logger,
 	}
@@ -58,6 +140,7 @@ func (f *fsm) Run() {
 			f.log(2, "trying to resync after error:", err)
 			f.consumer.ClientReader.Reset()
 			f.consumer.ServerReader.Reset()
+			f.pending = f.pending[:0]
 			f.state = f.readCommand
 			return
 		}
@@ -65,7 +148,7 @@ func (f *fsm) Run() {
 }
 
 func (f *fsm) peekBinaryProtocolMagicByte() error {
-	f.consumer.ServerReader.Truncate()
+	f.truncateServer()
 	firstByte, err := f.consumer.ClientReader.PeekN(1)
 	if err != nil {
 		if _, ok := err.(reader.ErrLostData); ok {
@@ -76,9 +159,13 @@ func (f *fsm) peekBinaryProtocolMagicByte() error {
 		return err
 	}
 	if firstByte[0] == 0x80 {
-		//binary memcached protocol, don't try to handle this connection
-		f.log(2, "looks like binary protocol, ignoring connection")
-		f.consumer.Close()
+		// binary memcached protocol, hand off this connection
+		f.log(2, "looks like binary protocol, switching parser")
+		fsm := mcbinary.NewFsm(f.logger)
+		fsm.SetConsumer(f.consumer)
+		f.consumer.Fsm = fsm
+		f.state = func() error { return io.EOF }
+		fsm.Run()
 		return io.EOF
 	}
 	f.state = f.readCommand
@@ -87,13 +174,17 @@ func (f *fsm) peekBinaryProtocolMagicByte() error {
 
 func (f *fsm) readCommand() error {
 	f.args = f.args[:0]
-	f.consumer.ServerReader.Truncate()
+	for k := range f.found {
+		delete(f.found, k)
+	}
+	f.truncateServer()
 	f.log(3, "reading command")
 	pos, err := f.consumer.ClientReader.IndexAny(" \n")
 	if err != nil {
 		return err
 	}
 
+	f.start = f.consumer.ClientReader.Seen()
 	cmd, err := f.consumer.ClientReader.ReadN(pos + 1)
 	if err != nil {
 		return err
@@ -106,7 +197,12 @@ func (f *fsm) readCommand() error {
 	}
 
 	if f.commandState() != nil {
-		f.state = f.readArgs
+		if cmd[len(cmd)-1] == '\n' {
+			// command without arguments
+			f.state = f.commandState()
+		} else {
+			f.state = f.readArgs
+		}
 		return nil
 	}
 
@@ -119,8 +215,20 @@ func (f *fsm) commandState() state {
 	switch f.cmd {
 	case "get", "gets":
 		return f.handleGet
+	case "gat", "gats":
+		return f.handleGat
 	case "set", "add", "replace", "append", "prepend", "cas":
 		return f.handleSet
+	case "delete":
+		return f.handleDelete
+	case "incr", "decr":
+		return f.handleArith
+	case "touch":
+		return f.handleTouch
+	case "flush_all":
+		return f.handleFlush
+	case "mg", "ms", "md", "ma", "mn":
+		return f.handleMeta
 	case "quit":
 		return f.handleQuit
 	default:
@@ -129,7 +237,7 @@ func (f *fsm) commandState() state {
 }
 
 func (f *fsm) readArgs() error {
-	f.consumer.ServerReader.Truncate()
+	f.truncateServer()
 	pos, err := f.consumer.ClientReader.IndexAny(" \n")
 	if err != nil {
 		return err
@@ -152,6 +260,24 @@ func (f *fsm) handleGet() error {
 	if len(f.args) < 1 {
 		return f.discardResponse()
 	}
+	return f.readValues(model.EventGetHit, model.EventGetMiss, f.args)
+}
+
+func (f *fsm) handleGat() error {
+	// first argument is the new expiration time
+	if len(f.args) < 2 {
+		return f.discardResponse()
+	}
+	return f.readValues(model.EventGatHit, model.EventGatMiss, f.args[1:])
+}
+
+// readValues reads VALUE lines from the server up to END, producing an
+// event of type hitType for each one.  Once END is reached, an event of type
+// missType is produced for each of keys that was not returned.
+func (f *fsm) readValues(hitType, missType model.EventType, keys []string) error {
+	if f.found == nil {
+		f.found = make(map[string]int)
+	}
 	for {
 		f.log(3, "awaiting server reply to get for", len(f.args), "keys")
 		line, err := f.consumer.ServerReader.ReadLine()
@@ -166,26 +292,48 @@ func (f *fsm) handleGet() error {
 			if err != nil {
 				return err
 			}
-			evt := model.Event{
-				Type: model.EventGetHit,
-				Key:  string(key),
-				Size: size,
-			}
-			// f.log("sending event:", evt)
-			f.addEvent(evt)
 			// f.log("discarding value")
 			_, err = f.consumer.ServerReader.Discard(size + len(crlf))
 			if err != nil {
 				return err
 			}
 			// f.log("discarded value")
+			evt := model.Event{
+				Type: hitType,
+				Key:  string(key),
+				Size: size,
+			}
+			// f.log("sending event:", evt)
+			f.addEvent(evt)
+			f.found[evt.Key]++
 		} else {
+			if bytes.Equal(line, []byte("END")) {
+				f.addMisses(missType, keys)
+			} else if class := errorClass(line); class != "" {
+				f.addErrors(class, keys...)
+			}
 			f.state = f.readCommand
 			return nil
 		}
 	}
 }
 
+// addMisses produces a miss event for each key that was requested but not
+// found.  A key requested more than once must be returned as many times to
+// count as a hit.
+func (f *fsm) addMisses(missType model.EventType, keys []string) {
+	for _, key := range keys {
+		if f.found[key] > 0 {
+			f.found[key]--
+			continue
+		}
+		f.addEvent(model.Event{
+			Type: missType,
+			Key:  key,
+		})
+	}
+}
+
 func (f *fsm) handleSet() error {
 	if len(f.args) < 4 {
 		return f.discardResponse()
@@ -199,8 +347,83 @@ func (f *fsm) handleSet() error {
 	if err != nil {
 		return err
 	}
-	f.log(3, "discarding response from server")
-	return f.discardResponse()
+	return f.awaitOutcome(storageEvents[f.cmd], f.args[0], size)
+}
+
+func (f *fsm) handleDelete() error {
+	if len(f.args) < 1 {
+		return f.discardResponse()
+	}
+	return f.awaitOutcome(model.EventDelete, f.args[0], 0)
+}
+
+func (f *fsm) handleArith() error {
+	if len(f.args) < 2 {
+		return f.discardResponse()
+	}
+	evtType := model.EventIncr
+	if f.cmd == "decr" {
+		evtType = model.EventDecr
+	}
+	return f.awaitOutcome(evtType, f.args[0], 0)
+}
+
+func (f *fsm) handleTouch() error {
+	if len(f.args) < 2 {
+		return f.discardResponse()
+	}
+	return f.awaitOutcome(model.EventTouch, f.args[0], 0)
+}
+
+func (f *fsm) handleFlush() error {
+	return f.awaitOutcome(model.EventFlush, "", 0)
+}
+
+// awaitOutcome reads the single line response to a command affecting key,
+// and produces an event recording the outcome.  If the client asked for no
+// reply the event is produced immediately without an outcome.
+func (f *fsm) awaitOutcome(evtType model.EventType, key string, size int) error {
+	if len(f.args) > 0 && f.args[len(f.args)-1] == "noreply" {
+		f.addEvent(model.Event{
+			Type: evtType,
+			Key:  key,
+			Size: size,
+		})
+		f.state = f.readCommand
+		return nil
+	}
+
+	f.state = func() error {
+		f.log(3, "awaiting server reply to", f.cmd)
+		line, err := f.consumer.ServerReader.ReadLine()
+		if err != nil {
+			return err
+		}
+		f.log(3, "server reply:", string(line))
+		f.state = f.readCommand
+		outcome, ok := outcomes[string(line)]
+		if !ok {
+			if class := errorClass(line); class != "" {
+				f.addErrors(class, key)
+				return nil
+			}
+			if len(line) == 0 || line[0] < '0' || line[0] > '9' {
+				// unknown response
+				return nil
+			}
+			// incr and decr respond with the new value
+			outcome = model.OutcomeOK
+			size = len(line)
+		}
+		f.addEvent(model.Event{
+			Type:    evtType,
+			Key:     key,
+			Size:    size,
+			Outcome: outcome,
+		})
+		return nil
+	}
+	return f.state()
 }
 
 func (f *fsm) handleQuit() error {
@@ -224,12 +447,77 @@ func (f *fsm) discardResponse() error {
 		return err
 	}
 	f.log(3, "discarded response from server:", string(line))
+	if class := errorClass(line); class != "" {
+		var key string
+		if len(f.args) > 0 {
+			key = f.args[0]
+		}
+		f.addErrors(class, key)
+	}
 	f.state = f.readCommand
 	return nil
 }
 
+// errorClass returns the type of an error response, such as SERVER_ERROR,
+// or the empty string if line is not an error.
+func errorClass(line []byte) string {
+	fields := bytes.SplitN(line, []byte(" "), 2)
+	if class := string(fields[0]); isError(class) {
+		return class
+	}
+	return ""
+}
+
+// addErrors records an error event for each of keys affected by the current
+// command.
+func (f *fsm) addErrors(class string, keys ...string) {
+	for _, key := range keys {
+		f.addEvent(model.Event{
+			Type:    model.EventError,
+			Key:     key,
+			Error:   class,
+			Command: commandName(f.cmd),
+		})
+	}
+}
+
+// commandName returns the name of the operation performed by a text or meta
+// command, as returned by EventType.Command, or the empty string if unknown.
+func commandName(cmd string) string {
+	if evtType, ok := storageEvents[cmd]; ok {
+		return evtType.Command()
+	}
+	if evtType, ok := commandEvents[cmd]; ok {
+		return evtType.Command()
+	}
+	return ""
+}
+
+// truncateServer discards any server data that cannot be a response to an
+// outstanding request.  Data is retained while there are pending meta commands
+// or buffered client commands, since it may hold responses to pipelined requests.
+func (f *fsm) truncateServer() {
+	if len(f.pending) > 0 {
+		return
+	}
+	if _, err := f.consumer.ClientReader.PeekN(1); err == nil {
+		return
+	}
+	f.consumer.ServerReader.Truncate()
+}
+
+// addEvent records evt as a result of the current command.
 func (f *fsm) addEvent(evt model.Event) {
-	f.consumer.AddEvent(evt)
+	f.addEventSince(f.start, evt)
+}
+
+// addEventSince records evt, with latency measured from start up to the end
+// of the response.
+func (f *fsm) addEventSince(start time.Time, evt model.Event) {
+	if f.routes {
+		evt = routeEvent(evt)
+	}
+	f.consumer.AddResponseEvent(evt, start)
 }
 
 func (f *fsm) log(level int, items ...interface{}) {
@@ -1,7 +1,9 @@
 package mctext
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/box/memsniff/log"
 	"github.com/box/memsniff/protocol/model"
@@ -16,8 +18,21 @@ func TestTextMulti(t *testing.T) {
 		"world",
 	}
 	testReadText(t, lines, []model.Event{
-		{model.EventGetHit, "key1", 5},
-		{model.EventGetHit, "key2", 5},
+		{Type: model.EventGetHit, Key: "key1", Size: 5},
+		{Type: model.EventGetHit, Key: "key2", Size: 5},
+	})
+}
+
+func TestTextMisses(t *testing.T) {
+	lines := []string{
+		"VALUE key2 0 5",
+		"hello",
+		"END",
+	}
+	testReadText(t, lines, []model.Event{
+		{Type: model.EventGetHit, Key: "key2", Size: 5},
+		{Type: model.EventGetMiss, Key: "key1"},
+		{Type: model.EventGetMiss, Key: "key3"},
 	})
 }
 
@@ -27,7 +42,7 @@ func TestTextEmptyValue(t *testing.T) {
 		"",
 	}
 	testReadText(t, lines, []model.Event{
-		{model.EventGetHit, "key3|foo", 0},
+		{Type: model.EventGetHit, Key: "key3|foo", Size: 0},
 	})
 }
 
@@ -38,7 +53,7 @@ func TestTextIncompleteHeader(t *testing.T) {
 		"VALUE ",
 	}
 	testReadText(t, lines, []model.Event{
-		{model.EventGetHit, "key1", 5},
+		{Type: model.EventGetHit, Key: "key1", Size: 5},
 	})
 }
 
@@ -48,10 +63,106 @@ func TestTextIncompleteBody(t *testing.T) {
 		"wor",
 	}
 	testReadText(t, lines, []model.Event{
-		{model.EventGetHit, "key1", 5},
+		{Type: model.EventGetHit, Key: "key1", Size: 5},
+	})
+}
+
+func TestStorage(t *testing.T) {
+	input := "set key1 0 0 5\r\nhello\r\nadd key2 0 0 3 noreply\r\nfoo\r\ncas key3 0 0 2 99\r\nhi\r\n"
+	output := "STORED\r\nEXISTS\r\n"
+	testConversation(t, input, output, []model.Event{
+		{Type: model.EventSet, Key: "key1", Size: 5, Outcome: model.OutcomeStored},
+		{Type: model.EventAdd, Key: "key2", Size: 3},
+		{Type: model.EventCas, Key: "key3", Size: 2, Outcome: model.OutcomeExists},
+	})
+}
+
+func TestDeleteArithTouch(t *testing.T) {
+	input := "delete key1\r\nincr key2 5\r\ndecr key3 1\r\ntouch key4 60\r\ngat 60 key5 key6\r\nflush_all\r\n"
+	output := "DELETED\r\n105\r\nNOT_FOUND\r\nTOUCHED\r\nVALUE key5 0 3\r\nfoo\r\nEND\r\nOK\r\n"
+	testConversation(t, input, output, []model.Event{
+		{Type: model.EventDelete, Key: "key1", Outcome: model.OutcomeDeleted},
+		{Type: model.EventIncr, Key: "key2", Size: 3, Outcome: model.OutcomeOK},
+		{Type: model.EventDecr, Key: "key3", Outcome: model.OutcomeNotFound},
+		{Type: model.EventTouch, Key: "key4", Outcome: model.OutcomeTouched},
+		{Type: model.EventGatHit, Key: "key5", Size: 3},
+		{Type: model.EventGatMiss, Key: "key6"},
+		{Type: model.EventFlush, Outcome: model.OutcomeOK},
+	})
+}
+
+func TestLatency(t *testing.T) {
+	var evts []model.Event
+	r := newConsumer(&log.ConsoleLogger{}, func(e []model.Event) {
+		evts = append(evts, e...)
+	})
+	start := time.Unix(100, 0)
+	r.ClientStream().Reassembled([]tcpassembly.Reassembly{{
+		Bytes: []byte("get key1\r\n"),
+		Seen:  start,
+	}})
+	r.ServerStream().Reassembled([]tcpassembly.Reassembly{{
+		Bytes: []byte("VALUE key1 0 5\r\nhello\r\nEND\r\n"),
+		Seen:  start.Add(3 * time.Millisecond),
+	}})
+	r.ClientStream().ReassemblyComplete()
+	r.ServerStream().ReassemblyComplete()
+
+	if len(evts) != 1 || evts[0].Latency != 3*time.Millisecond {
+		t.Error(evts)
+	}
+}
+
+func TestLatencySplitValue(t *testing.T) {
+	var evts []model.Event
+	r := newConsumer(&log.ConsoleLogger{}, func(e []model.Event) {
+		evts = append(evts, e...)
+	})
+	start := time.Unix(100, 0)
+	value := strings.Repeat("x", 3000)
+	r.ClientStream().Reassembled([]tcpassembly.Reassembly{{
+		Bytes: []byte("get key1\r\n"),
+		Seen:  start,
+	}})
+	r.ServerStream().Reassembled([]tcpassembly.Reassembly{{
+		Bytes: []byte("VALUE key1 0 3000\r\n" + value[:1000]),
+		Seen:  start.Add(time.Millisecond),
+	}})
+	r.ServerStream().Reassembled([]tcpassembly.Reassembly{{
+		Bytes: []byte(value[1000:] + "\r\nEND\r\n"),
+		Seen:  start.Add(51 * time.Millisecond),
+	}})
+	r.ClientStream().ReassemblyComplete()
+	r.ServerStream().ReassemblyComplete()
+
+	if len(evts) != 1 || evts[0].Latency != 51*time.Millisecond {
+		t.Error(evts)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	input := "get key1 key2\r\nset key3 0 0 5\r\nhello\r\nfoo\r\nincr key4 1\r\nmg key5 v\r\n"
+	output := "SERVER_ERROR out of memory\r\nSERVER_ERROR out of memory storing object\r\nERROR\r\n" +
+		"CLIENT_ERROR cannot increment or decrement non-numeric value\r\nCLIENT_ERROR bad data chunk\r\n"
+	testConversation(t, input, output, []model.Event{
+		{Type: model.EventError, Key: "key1", Error: "SERVER_ERROR", Command: "get"},
+		{Type: model.EventError, Key: "key2", Error: "SERVER_ERROR", Command: "get"},
+		{Type: model.EventError, Key: "key3", Error: "SERVER_ERROR", Command: "set"},
+		{Type: model.EventError, Error: "ERROR"},
+		{Type: model.EventError, Key: "key4", Error: "CLIENT_ERROR", Command: "incr"},
+		{Type: model.EventError, Key: "key5", Error: "CLIENT_ERROR", Command: "get"},
 	})
 }
 
+func TestBinaryHandoff(t *testing.T) {
+	r := newConsumer(&log.ConsoleLogger{}, nil)
+	fsm := r.Fsm
+	r.ClientStream().Reassembled(reassemblyString("\x80\x00\x00\x00"))
+	if r.Fsm == fsm {
+		t.Error("did not switch parser on binary protocol magic byte")
+	}
+}
+
 func TestClientOverrun(t *testing.T) {
 	r := newConsumer(&log.ConsoleLogger{}, nil)
 	var data [1024]byte
@@ -68,7 +179,7 @@ func TestServerOverrun(t *testing.T) {
 }
 
 func newConsumer(logger log.Logger, handler model.EventHandler) *model.Consumer {
-	return model.New(handler, NewFsm(logger))
+	return model.New(handler, NewFsm(logger, model.Options{}))
 }
 
 func testReadText(t *testing.T, lines []string, expected []model.Event) {
@@ -94,6 +205,35 @@ func testReadText(t *testing.T, lines []string, expected []model.Event) {
 	}
 }
 
+func testConversation(t *testing.T, input string, output string, expected []model.Event) {
+	testFsmConversation(t, NewFsm(&log.ConsoleLogger{}, model.Options{}), input, output, expected)
+}
+
+func testFsmConversation(t *testing.T, fsm model.Fsm, input string, output string, expected []model.Event) {
+	handler := func(evts []model.Event) {
+		for _, e := range evts {
+			if len(expected) == 0 {
+				t.Error("Unexpected event", e)
+				continue
+			}
+			if e != expected[0] {
+				t.Error("Expected", expected[0], "got", e)
+			}
+			expected = expected[1:]
+		}
+	}
+	r := model.New(handler, fsm)
+
+	r.ClientStream().Reassembled(reassemblyString(input))
+	r.ServerStream().Reassembled(reassemblyString(output))
+	r.ClientStream().ReassemblyComplete()
+	r.ServerStream().ReassemblyComplete()
+
+	if len(expected) > 0 {
+		t.Error("Expected", expected, "events but never received")
+	}
+}
+
 func reassemblyString(s string) []tcpassembly.Reassembly {
 	return []tcpassembly.Reassembly{{Bytes: []byte(s)}}
 }
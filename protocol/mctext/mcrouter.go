@@ -0,0 +1,46 @@
+package mctext
+
+import (
+	"strings"
+
+	"github.com/box/memsniff/protocol/model"
+)
+
+// mcrouterPrefix starts the keys of mcrouter introspection commands, such as
+// get __mcrouter__.version.
+const mcrouterPrefix = "__mcrouter__."
+
+// splitRoute separates a routing prefix of the form /region/cluster/ from
+// key.  Keys without a complete prefix are returned unchanged.
+func splitRoute(key string) (route, rest string) {
+	if len(key) == 0 || key[0] != '/' {
+		return "", key
+	}
+	region := strings.IndexByte(key[1:], '/')
+	if region < 0 {
+		return "", key
+	}
+	end := 1 + region + 1
+	cluster := strings.IndexByte(key[end:], '/')
+	if cluster < 0 {
+		return "", key
+	}
+	end += cluster + 1
+	return key[:end], key[end:]
+}
+
+// routeEvent moves the routing prefix of the key of evt to its Route field,
+// and reports introspection commands as EventIntrospect, keyed by the name of
+// the property requested.
+func routeEvent(evt model.Event) model.Event {
+	evt.Route, evt.Key = splitRoute(evt.Key)
+	if evt.Type == model.EventError || !strings.HasPrefix(evt.Key, mcrouterPrefix) {
+		return evt
+	}
+	if evt.Type == model.EventGetMiss {
+		evt.Outcome = model.OutcomeNotFound
+	}
+	evt.Type = model.EventIntrospect
+	evt.Key = evt.Key[len(mcrouterPrefix):]
+	return evt
+}
@@ -0,0 +1,57 @@
+package mctext
+
+import (
+	"testing"
+
+	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/protocol/model"
+)
+
+func TestSplitRoute(t *testing.T) {
+	cases := []struct{ key, route, rest string }{
+		{"/region/cluster/foo:123", "/region/cluster/", "foo:123"},
+		{"/*/*/foo", "/*/*/", "foo"},
+		{"/region/foo", "", "/region/foo"},
+		{"foo/bar/baz", "", "foo/bar/baz"},
+		{"", "", ""},
+	}
+	for _, c := range cases {
+		route, rest := splitRoute(c.key)
+		if route != c.route || rest != c.rest {
+			t.Error(c.key, route, rest)
+		}
+	}
+}
+
+func TestRouterRoutes(t *testing.T) {
+	input := "get /east/main/foo:123 /west/main/foo:123\r\n" +
+		"set /east/main/bar 0 0 3\r\nbaz\r\n"
+	output := "VALUE /east/main/foo:123 0 5\r\nhello\r\nEND\r\n" +
+		"STORED\r\n"
+	testFsmConversation(t, newRouterFsm(), input, output, []model.Event{
+		{Type: model.EventGetHit, Key: "foo:123", Route: "/east/main/", Size: 5},
+		{Type: model.EventGetMiss, Key: "foo:123", Route: "/west/main/"},
+		{Type: model.EventSet, Key: "bar", Route: "/east/main/", Size: 3, Outcome: model.OutcomeStored},
+	})
+}
+
+func TestRouterIntrospection(t *testing.T) {
+	input := "get __mcrouter__.version\r\n" +
+		"get __mcrouter__.nosuch\r\n"
+	output := "VALUE __mcrouter__.version 0 6\r\n37.0.0\r\nEND\r\n" +
+		"END\r\n"
+	testFsmConversation(t, newRouterFsm(), input, output, []model.Event{
+		{Type: model.EventIntrospect, Key: "version", Size: 6},
+		{Type: model.EventIntrospect, Key: "nosuch", Outcome: model.OutcomeNotFound},
+	})
+}
+
+func TestTextKeepsRoutes(t *testing.T) {
+	testConversation(t, "delete /east/main/foo\r\n", "DELETED\r\n", []model.Event{
+		{Type: model.EventDelete, Key: "/east/main/foo", Outcome: model.OutcomeDeleted},
+	})
+}
+
+func newRouterFsm() model.Fsm {
+	return NewFsm(&log.ConsoleLogger{}, model.Options{SplitRoutes: true})
+}
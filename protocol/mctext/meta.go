@@ -0,0 +1,353 @@
+package mctext
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/box/memsniff/protocol/model"
+)
+
+// errUnmatchedResponse is returned when a meta response does not match any
+// pending command, meaning commands were lost and later responses cannot be
+// matched either.
+var errUnmatchedResponse = errors.New("meta response did not match any pending command")
+
+// maxPending limits the number of meta commands awaiting a response, to bound
+// memory used by connections where responses are lost.
+const maxPending = 1024
+
+// metaRequest is a meta command awaiting a response from the server.
+type metaRequest struct {
+	cmd    string
+	key    string
+	opaque string
+	// quiet is set if the server will not send the most common response.
+	quiet bool
+	// size is the length of the data block sent with ms.
+	size int
+	// evtType is the event produced by ms, md and ma, based on mode flags.
+	evtType model.EventType
+	// start is the capture time of the first byte of the command.
+	start time.Time
+}
+
+// commandName returns the name of the operation performed by req, as returned
+// by EventType.Command, or the empty string for mn.
+func (req metaRequest) commandName() string {
+	if req.evtType != model.EventUnknown {
+		return req.evtType.Command()
+	}
+	return commandName(req.cmd)
+}
+
+// parseMetaRequest builds a metaRequest from the current command and its arguments.
+func (f *fsm) parseMetaRequest() (metaRequest, error) {
+	req := metaRequest{cmd: f.cmd, start: f.start}
+	switch f.cmd {
+	case "ms":
+		req.evtType = model.EventSet
+	case "md":
+		req.evtType = model.EventDelete
+	case "ma":
+		req.evtType = model.EventIncr
+	}
+	if f.cmd == "mn" {
+		return req, nil
+	}
+	if len(f.args) < 1 {
+		return req, errProtocolDesync
+	}
+	req.key = f.args[0]
+	flags := f.args[1:]
+	if f.cmd == "ms" {
+		if len(flags) < 1 {
+			return req, errProtocolDesync
+		}
+		size, err := strconv.Atoi(flags[0])
+		if err != nil {
+			return req, err
+		}
+		req.size = size
+		flags = flags[1:]
+	}
+
+	var b64 bool
+	for _, flag := range flags {
+		if flag == "" {
+			continue
+		}
+		switch flag[0] {
+		case 'q':
+			req.quiet = true
+		case 'O':
+			req.opaque = flag[1:]
+		case 'b':
+			b64 = true
+		case 'C':
+			if f.cmd == "ms" {
+				req.evtType = model.EventCas
+			}
+		case 'M':
+			if len(flag) > 1 {
+				req.evtType = metaModeEvent(f.cmd, flag[1], req.evtType)
+			}
+		}
+	}
+	if b64 {
+		if key, err := base64.StdEncoding.DecodeString(req.key); err == nil {
+			req.key = string(key)
+		}
+	}
+	return req, nil
+}
+
+// metaModeEvent returns the event type for cmd with the mode flag M<mode>.
+func metaModeEvent(cmd string, mode byte, evtType model.EventType) model.EventType {
+	switch cmd {
+	case "ms":
+		if evtType == model.EventCas {
+			// compare-and-swap overrides the mode
+			return evtType
+		}
+		switch mode {
+		case 'E', 'e':
+			return model.EventAdd
+		case 'A', 'a':
+			return model.EventAppend
+		case 'P', 'p':
+			return model.EventPrepend
+		case 'R', 'r':
+			return model.EventReplace
+		}
+	case "ma":
+		switch mode {
+		case 'D', 'd', '-':
+			return model.EventDecr
+		}
+	}
+	return evtType
+}
+
+func (f *fsm) handleMeta() error {
+	req, err := f.parseMetaRequest()
+	if err != nil {
+		return err
+	}
+	if req.cmd == "ms" {
+		// the data block is terminated even when empty
+		f.log(3, "discarding", req.size+len(crlf), "from client")
+		_, err = f.consumer.ClientReader.Discard(req.size + len(crlf))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(f.pending) >= maxPending {
+		f.log(2, "too many pending meta commands, discarding", f.pending[0])
+		f.pending = f.pending[1:]
+	}
+	f.pending = append(f.pending, req)
+	if req.quiet {
+		// the server may not reply, continue reading pipelined commands
+		f.state = f.readCommand
+	} else {
+		f.state = f.readMetaResponse
+	}
+	return nil
+}
+
+func (f *fsm) readMetaResponse() error {
+	f.log(3, "awaiting server reply to meta command for", len(f.pending), "requests")
+	line, err := f.consumer.ServerReader.ReadLine()
+	if err != nil {
+		return err
+	}
+	f.log(3, "server reply:", string(line))
+	fields := bytes.Split(line, []byte(" "))
+	code := string(fields[0])
+	flags := fields[1:]
+
+	var size int
+	if code == "VA" {
+		if len(fields) < 2 {
+			return errProtocolDesync
+		}
+		size, err = strconv.Atoi(string(fields[1]))
+		if err != nil {
+			return err
+		}
+		flags = fields[2:]
+		_, err = f.consumer.ServerReader.Discard(size + len(crlf))
+		if err != nil {
+			return err
+		}
+	}
+
+	if isError(code) {
+		// followed by a message rather than flags
+		flags = nil
+	}
+	var opaque, key string
+	for _, flag := range flags {
+		if len(flag) == 0 {
+			continue
+		}
+		switch flag[0] {
+		case 'O':
+			opaque = string(flag[1:])
+		case 'k':
+			key = string(flag[1:])
+		case 's':
+			if code != "VA" {
+				// value size requested without the value itself
+				size, _ = strconv.Atoi(string(flag[1:]))
+			}
+		}
+	}
+
+	req, ok := f.matchPending(code, opaque, key)
+	if !ok {
+		f.log(2, "unmatched meta response:", string(line))
+		return errUnmatchedResponse
+	}
+	f.handleMetaResponse(req, code, size)
+	if !req.quiet {
+		f.state = f.readCommand
+	}
+	return nil
+}
+
+// matchPending finds the meta command to which the server is responding.
+//
+// Responses arrive in the same order as commands, but quiet commands do not
+// receive their most common response.  Responses are matched by opaque token
+// or key when the client asked for them to be returned.  Any quiet commands
+// that precede the matching command have therefore completed silently and are
+// removed.  Only responses that the command can produce are matched.
+func (f *fsm) matchPending(code string, opaque string, key string) (metaRequest, bool) {
+	for i, req := range f.pending {
+		var match bool
+		switch {
+		case !isError(code) && !metaResponds(req.cmd, code):
+			match = false
+		case opaque != "":
+			match = req.opaque == opaque
+		case key != "" && req.cmd != "mn":
+			match = req.key == key
+		case isError(code):
+			match = true
+		default:
+			match = !req.quiet || code != metaSuppressed(req.cmd)
+		}
+		if match {
+			for _, skipped := range f.pending[:i] {
+				f.handleMetaResponse(skipped, metaSuppressed(skipped.cmd), 0)
+			}
+			f.pending = f.pending[i+1:]
+			return req, true
+		}
+		if !req.quiet {
+			break
+		}
+	}
+	return metaRequest{}, false
+}
+
+// isError returns true if code is a generic error response.
+func isError(code string) bool {
+	return code == "ERROR" || code == "CLIENT_ERROR" || code == "SERVER_ERROR"
+}
+
+// metaResponds returns true if code is a possible non-error response to cmd.
+func metaResponds(cmd string, code string) bool {
+	switch cmd {
+	case "mg":
+		return code == "VA" || code == "HD" || code == "EN"
+	case "ms":
+		return code == "HD" || code == "NS" || code == "EX" || code == "NF"
+	case "md":
+		return code == "HD" || code == "EX" || code == "NF"
+	case "ma":
+		return code == "VA" || code == "HD" || code == "NS" || code == "EX" || code == "NF"
+	case "mn":
+		return code == "MN"
+	default:
+		return false
+	}
+}
+
+// metaSuppressed returns the response the server omits for cmd in quiet mode.
+func metaSuppressed(cmd string) string {
+	if cmd == "mg" {
+		return "EN"
+	}
+	return "HD"
+}
+
+func (f *fsm) handleMetaResponse(req metaRequest, code string, size int) {
+	if isError(code) {
+		f.addEventSince(req.start, model.Event{
+			Type:    model.EventError,
+			Key:     req.key,
+			Error:   code,
+			Command: req.commandName(),
+		})
+		return
+	}
+	switch req.cmd {
+	case "mg":
+		switch code {
+		case "VA", "HD":
+			f.addEventSince(req.start, model.Event{
+				Type: model.EventGetHit,
+				Key:  req.key,
+				Size: size,
+			})
+		case "EN":
+			f.addEventSince(req.start, model.Event{
+				Type: model.EventGetMiss,
+				Key:  req.key,
+			})
+		}
+	case "ms", "md", "ma":
+		outcome, ok := metaOutcome(req.cmd, code)
+		if !ok {
+			return
+		}
+		if req.cmd == "ms" {
+			size = req.size
+		}
+		f.addEventSince(req.start, model.Event{
+			Type:    req.evtType,
+			Key:     req.key,
+			Size:    size,
+			Outcome: outcome,
+		})
+	}
+}
+
+// metaOutcome maps a response code for a modifying meta command to an outcome.
+func metaOutcome(cmd string, code string) (model.Outcome, bool) {
+	switch code {
+	case "HD", "VA":
+		switch cmd {
+		case "ms":
+			return model.OutcomeStored, true
+		case "md":
+			return model.OutcomeDeleted, true
+		default:
+			return model.OutcomeOK, true
+		}
+	case "NS":
+		return model.OutcomeNotStored, true
+	case "EX":
+		return model.OutcomeExists, true
+	case "NF":
+		return model.OutcomeNotFound, true
+	default:
+		return model.OutcomeNone, false
+	}
+}
@@ -0,0 +1,83 @@
+package mctext
+
+import (
+	"testing"
+
+	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/protocol/model"
+)
+
+func TestMetaGet(t *testing.T) {
+	input := "mg key1 v\r\nmg key2 v\r\nmg key3 s\r\n"
+	output := "VA 5\r\nhello\r\nEN\r\nHD s12\r\n"
+	testConversation(t, input, output, []model.Event{
+		{Type: model.EventGetHit, Key: "key1", Size: 5},
+		{Type: model.EventGetMiss, Key: "key2"},
+		{Type: model.EventGetHit, Key: "key3", Size: 12},
+	})
+}
+
+func TestMetaQuietGet(t *testing.T) {
+	input := "mg key1 v q\r\nmg key2 v q\r\nmg key3 v q\r\nmn\r\n"
+	output := "VA 5\r\nhello\r\nMN\r\n"
+	testConversation(t, input, output, []model.Event{
+		{Type: model.EventGetHit, Key: "key1", Size: 5},
+		{Type: model.EventGetMiss, Key: "key2"},
+		{Type: model.EventGetMiss, Key: "key3"},
+	})
+}
+
+func TestMetaQuietOpaque(t *testing.T) {
+	input := "mg key1 v q O1\r\nmg key2 v q O2\r\nmn\r\n"
+	output := "VA 3 O2\r\nfoo\r\nMN\r\n"
+	testConversation(t, input, output, []model.Event{
+		{Type: model.EventGetMiss, Key: "key1"},
+		{Type: model.EventGetHit, Key: "key2", Size: 3},
+	})
+}
+
+func TestMetaWrites(t *testing.T) {
+	input := "ms key1 5 T60\r\nhello\r\nmd key2 q\r\nma key3 MD v\r\nmd key4\r\nms key5 2 ME C99\r\nhi\r\nmn\r\n"
+	output := "HD\r\nVA 1\r\n7\r\nNF\r\nEX\r\nMN\r\n"
+	testConversation(t, input, output, []model.Event{
+		{Type: model.EventSet, Key: "key1", Size: 5, Outcome: model.OutcomeStored},
+		{Type: model.EventDelete, Key: "key2", Outcome: model.OutcomeDeleted},
+		{Type: model.EventDecr, Key: "key3", Size: 1, Outcome: model.OutcomeOK},
+		{Type: model.EventDelete, Key: "key4", Outcome: model.OutcomeNotFound},
+		{Type: model.EventCas, Key: "key5", Size: 2, Outcome: model.OutcomeExists},
+	})
+}
+
+func TestMetaEmptySet(t *testing.T) {
+	input := "ms key1 0\r\n\r\nmg key2 v\r\n"
+	output := "HD\r\nVA 2\r\nhi\r\n"
+	testConversation(t, input, output, []model.Event{
+		{Type: model.EventSet, Key: "key1", Outcome: model.OutcomeStored},
+		{Type: model.EventGetHit, Key: "key2", Size: 2},
+	})
+}
+
+func TestMetaUnmatchedResponse(t *testing.T) {
+	var evts []model.Event
+	c := newConsumer(&log.ConsoleLogger{}, func(e []model.Event) {
+		evts = append(evts, e...)
+	})
+	c.ClientStream().Reassembled(reassemblyString("mg key1 v O1\r\n"))
+	c.ServerStream().Reassembled(reassemblyString("HD O2\r\n"))
+	c.ClientStream().Reassembled(reassemblyString("get key2\r\n"))
+	c.ServerStream().Reassembled(reassemblyString("VALUE key2 0 2\r\nhi\r\nEND\r\n"))
+	c.FlushEvents()
+
+	expected := []model.Event{{Type: model.EventGetHit, Key: "key2", Size: 2}}
+	if len(evts) != len(expected) || evts[0] != expected[0] {
+		t.Error(evts)
+	}
+}
+
+func TestMetaBase64Key(t *testing.T) {
+	input := "mg a2V5MQ== b v\r\n"
+	output := "VA 2 b\r\nhi\r\n"
+	testConversation(t, input, output, []model.Event{
+		{Type: model.EventGetHit, Key: "key1", Size: 2},
+	})
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"io"
 	"sync"
+	"time"
 
 	"github.com/box/memsniff/assembly/reader"
 	"github.com/google/gopacket/tcpassembly"
@@ -57,6 +58,13 @@ type Reader interface {
 
 	// Truncate discards all buffered data from the reader, leaving other state intact.
 	Truncate()
+
+	// Seen returns the capture time of the next unread byte, or the zero time if
+	// no data is available.
+	Seen() time.Time
+
+	// LastSeen returns the capture time of the most recently consumed byte.
+	LastSeen() time.Time
 }
 
 // ConsumerSource buffers tcpassembly.Stream data and exposes it as a closeable Reader.
@@ -75,8 +83,25 @@ type Consumer struct {
 	// ServerReader exposes data send by the server to the client.
 	ServerReader *reader.Reader
 
+	// ClientAddr, ClientPort, ServerAddr and ServerPort identify the endpoints
+	// of the conversation, and are recorded in every event.
+	ClientAddr string
+	ClientPort int
+	ServerAddr string
+	ServerPort int
+
 	Fsm      Fsm
 	eventBuf []Event
+	// held are events waiting for discarded server data to be captured.
+	held []heldEvent
+}
+
+// heldEvent is an event waiting for the end of its response to be captured.
+type heldEvent struct {
+	evt Event
+	// start is the capture time of the start of the command, or the zero
+	// time if latency is not measured.
+	start time.Time
 }
 
 func New(handler EventHandler, fsm Fsm) *Consumer {
@@ -92,7 +117,44 @@ func New(handler EventHandler, fsm Fsm) *Consumer {
 	return c
 }
 
+// AddEvent records evt, after any events still waiting for their responses.
 func (c *Consumer) AddEvent(evt Event) {
+	c.holdEvent(evt, time.Time{})
+}
+
+// AddResponseEvent records evt as the result of a command whose first byte
+// was captured at start, with latency measured to the capture of the last
+// byte of the response.  Server data that has been discarded but not yet
+// captured, such as the rest of a large value, is part of the response, so
+// evt is held until it arrives.
+func (c *Consumer) AddResponseEvent(evt Event, start time.Time) {
+	c.holdEvent(evt, start)
+}
+
+func (c *Consumer) holdEvent(evt Event, start time.Time) {
+	c.held = append(c.held, heldEvent{evt, start})
+	if c.ServerReader.Discarding() == 0 {
+		c.releaseEvents()
+	}
+}
+
+// releaseEvents records all held events, measuring latency up to the most
+// recently captured server data.
+func (c *Consumer) releaseEvents() {
+	for _, h := range c.held {
+		if !h.start.IsZero() {
+			h.evt.Latency = c.Latency(h.start)
+		}
+		c.bufferEvent(h.evt)
+	}
+	c.held = c.held[:0]
+}
+
+func (c *Consumer) bufferEvent(evt Event) {
+	evt.ClientAddr = c.ClientAddr
+	evt.ClientPort = c.ClientPort
+	evt.ServerAddr = c.ServerAddr
+	evt.ServerPort = c.ServerPort
 	if c.eventBuf == nil {
 		c.eventBuf = make([]Event, 0, 8)
 	}
@@ -102,12 +164,23 @@ func (c *Consumer) AddEvent(evt Event) {
 	}
 }
 
+// Latency returns the time elapsed between start and the capture of the most
+// recently consumed byte from the server, or zero if unknown.
+func (c *Consumer) Latency(start time.Time) time.Duration {
+	end := c.ServerReader.LastSeen()
+	if start.IsZero() || end.Before(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
 func (c *Consumer) FlushEvents() {
 	c.Handler(c.eventBuf)
 	c.eventBuf = c.eventBuf[:0]
 }
 
 func (c *Consumer) Close() {
+	c.releaseEvents()
 	if c.ClientReader != eofSource {
 		c.ClientReader.Reset()
 		bufferPool.Put(c.ClientReader)
@@ -155,12 +228,16 @@ type ServerStream Consumer
 func (ss *ServerStream) Reassembled(rs []tcpassembly.Reassembly) {
 	for _, r := range rs {
 		ss.ServerReader.Reassembled([]tcpassembly.Reassembly{r})
+		if len(ss.held) > 0 && ss.ServerReader.Discarding() == 0 {
+			(*Consumer)(ss).releaseEvents()
+		}
 		(*Consumer)(ss).Fsm.Run()
 	}
 }
 
 func (ss *ServerStream) ReassemblyComplete() {
 	ss.ServerReader.ReassemblyComplete()
+	(*Consumer)(ss).releaseEvents()
 	(*Consumer)(ss).FlushEvents()
 	if ss.ServerReader != eofSource {
 		ss.ServerReader.Reset()
@@ -2,6 +2,7 @@ package model
 
 import (
 	"io"
+	"time"
 
 	"github.com/google/gopacket/tcpassembly"
 )
@@ -43,3 +44,11 @@ func (s *DummySource) Close() error {
 func (s *DummySource) Reset() {}
 
 func (s *DummySource) Truncate() {}
+
+func (s *DummySource) Seen() time.Time {
+	return time.Time{}
+}
+
+func (s *DummySource) LastSeen() time.Time {
+	return time.Time{}
+}
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 // EventType described what sort of event has occurred.
 type EventType int
 
@@ -10,6 +12,147 @@ const (
 	EventGetHit
 	// EventGetMiss is a data retrieval that did not result in data.
 	EventGetMiss
+	// EventSet is an unconditional storage of a value.
+	EventSet
+	// EventDelete is a removal of a key.
+	EventDelete
+	// EventIncr is an increment of a numeric value.
+	EventIncr
+	// EventDecr is a decrement of a numeric value.
+	EventDecr
+	// EventAdd is a storage of a value only if the key does not already exist.
+	EventAdd
+	// EventReplace is a storage of a value only if the key already exists.
+	EventReplace
+	// EventAppend adds data to the end of an existing value.
+	EventAppend
+	// EventPrepend adds data to the start of an existing value.
+	EventPrepend
+	// EventCas is a storage of a value only if it is unchanged since last retrieved.
+	EventCas
+	// EventTouch updates the expiration time of a key.
+	EventTouch
+	// EventGatHit is a retrieval that updated the expiration time and returned data.
+	EventGatHit
+	// EventGatMiss is a retrieval that updated the expiration time but did not result in data.
+	EventGatMiss
+	// EventFlush invalidates all keys.
+	EventFlush
+	// EventRead is a retrieval without a more specific event type, such as
+	// reading a field of a data structure.
+	EventRead
+	// EventWrite is a modification without a more specific event type, such
+	// as adding an element to a data structure.
+	EventWrite
+	// EventScript is a server-side script or function invocation.
+	EventScript
+	// EventPublish is a message sent to a pub/sub channel.
+	EventPublish
+	// EventMessage is a message delivered to a pub/sub subscriber.
+	EventMessage
+	// EventRedirect is a command rejected because the key is served by
+	// another node of a cluster.
+	EventRedirect
+	// EventError is a command that the server responded to with an error.
+	EventError
+	// EventIntrospect is a request for the internal state of a proxy, such
+	// as mcrouter's __mcrouter__.* keys.
+	EventIntrospect
+)
+
+var commandNames = [...]string{
+	EventUnknown:    "unknown",
+	EventGetHit:     "get",
+	EventGetMiss:    "get",
+	EventSet:        "set",
+	EventDelete:     "delete",
+	EventIncr:       "incr",
+	EventDecr:       "decr",
+	EventAdd:        "add",
+	EventReplace:    "replace",
+	EventAppend:     "append",
+	EventPrepend:    "prepend",
+	EventCas:        "cas",
+	EventTouch:      "touch",
+	EventGatHit:     "gat",
+	EventGatMiss:    "gat",
+	EventFlush:      "flush",
+	EventRead:       "read",
+	EventWrite:      "write",
+	EventScript:     "script",
+	EventPublish:    "publish",
+	EventMessage:    "message",
+	EventRedirect:   "redirect",
+	EventError:      "error",
+	EventIntrospect: "introspect",
+}
+
+// Command returns the name of the datastore operation that produces events of
+// this type.  Events that differ only in their result, such as EventGetHit and
+// EventGetMiss, share the same command name.
+func (t EventType) Command() string {
+	if t < 0 || int(t) >= len(commandNames) {
+		return commandNames[EventUnknown]
+	}
+	return commandNames[t]
+}
+
+// CommandName returns the name of the datastore operation that produced e, as
+// returned by EventType.Command.  Error events are named for the operation
+// that failed, if known.
+func (e Event) CommandName() string {
+	if e.Type == EventError && e.Command != "" {
+		return e.Command
+	}
+	return e.Type.Command()
+}
+
+// IsHit returns true if t is a retrieval that returned data.
+func (t EventType) IsHit() bool {
+	return t == EventGetHit || t == EventGatHit
+}
+
+// IsMiss returns true if t is a retrieval that did not find data.
+func (t EventType) IsMiss() bool {
+	return t == EventGetMiss || t == EventGatMiss
+}
+
+// IsCommand returns true if name is a command name returned by EventType.Command.
+func IsCommand(name string) bool {
+	for _, n := range commandNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Outcome describes the result of a command as reported by the server.
+type Outcome int
+
+const (
+	// OutcomeNone means no result was reported, or the command has no result
+	// beyond its event type.
+	OutcomeNone Outcome = iota
+	// OutcomeOK is a successful command without a more specific outcome.
+	OutcomeOK
+	// OutcomeStored means a value was written.
+	OutcomeStored
+	// OutcomeNotStored means a conditional write was not performed.
+	OutcomeNotStored
+	// OutcomeExists means a CAS write failed because the value was modified.
+	OutcomeExists
+	// OutcomeNotFound means the key did not exist.
+	OutcomeNotFound
+	// OutcomeDeleted means the key was removed.
+	OutcomeDeleted
+	// OutcomeTouched means the expiration time of the key was updated.
+	OutcomeTouched
+	// OutcomeMoved means the key has permanently moved to another node.
+	OutcomeMoved
+	// OutcomeAsk means the key is migrating and should be requested from
+	// another node for this command only.
+	OutcomeAsk
 )
 
 // Event is a single event in a datastore conversation
@@ -20,6 +163,42 @@ type Event struct {
 	Key string
 	// Size of the datastore value affected by this event.
 	Size int
+	// Outcome of the command, for commands that modify data.
+	Outcome Outcome
+	// Latency is the time from the capture of the first byte of the command
+	// to the capture of the last byte of the response read when the event was
+	// produced, or zero if unknown.
+	Latency time.Duration
+	// ClientAddr is the network address of the client.
+	ClientAddr string
+	// ClientPort is the TCP port of the client.
+	ClientPort int
+	// ServerAddr is the network address of the server.
+	ServerAddr string
+	// ServerPort is the TCP port of the server.
+	ServerPort int
+	// DB is the database number selected by the client, for datastores that
+	// support more than one.
+	DB int
+	// User is the name the client authenticated as, if known.
+	User string
+	// Script identifies the server-side script or function that was run,
+	// such as the SHA1 digest of a Lua script.
+	Script string
+	// Slot is the hash slot of Key, for datastores that shard keys by slot
+	// such as Redis Cluster, if requested with Options.KeySlots.
+	Slot int
+	// Target is the address of the node a command was redirected to.
+	Target string
+	// Error is the class of error reported by the server, such as
+	// SERVER_ERROR or WRONGTYPE.
+	Error string
+	// Command is the name of the operation that failed, for error events, as
+	// returned by EventType.Command, or empty if unknown.
+	Command string
+	// Route is the routing prefix of the key for proxies that support one,
+	// such as /region/cluster/ for mcrouter.
+	Route string
 }
 
 // EventHandler consumes a batch of events.
@@ -34,6 +213,19 @@ const (
 	FieldNone EventFieldMask = 0
 	FieldKey  EventFieldMask = 1 << iota
 	FieldSize
+	FieldCmd
+	FieldLatency
+	FieldClient
+	FieldClientPort
+	FieldServer
+	FieldDB
+	FieldUser
+	FieldScript
+	FieldSlot
+	FieldTarget
+	FieldError
+	FieldRoute
+	FieldKeyPattern
 
 	// FieldEndOfFields is a dummy value to use as the endpoint of an iteration.
 	FieldEndOfFields
@@ -42,5 +234,5 @@ const (
 const (
 	// IntFields is a mask identifying the set of fields that can be viewed as integers,
 	// and are viable targets for aggregation.
-	IntFields = FieldSize
+	IntFields = FieldSize | FieldLatency
 )
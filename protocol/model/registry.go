@@ -0,0 +1,144 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/box/memsniff/log"
+)
+
+// Protocol describes a datastore protocol that memsniff can decode.
+// Protocol packages register themselves with Register from an init function,
+// so linking a package into the binary is enough to make its protocol
+// available on the command line and to inference.
+type Protocol struct {
+	// Name identifies the protocol in command line options, such as "redis".
+	Name string
+	// NewFsm returns a parser for a single connection.
+	NewFsm func(logger log.Logger, opts Options) Fsm
+	// Ports are the server ports the protocol is conventionally served on.
+	Ports []int
+	// Sniff returns the confidence, from 0 to 1, that a conversation starting
+	// with the given client and server data uses this protocol.  Either slice
+	// may be empty if nothing has been seen from that side yet.  A score of 1
+	// is conclusive; lower scores only decide the protocol when the server
+	// port is one of Ports or no better candidate appears.
+	// Protocols with a nil Sniff are never inferred.
+	Sniff func(client, server []byte) float64
+}
+
+// Options adjusts how Fsms decode traffic.  Protocols ignore options that do
+// not apply to them.
+type Options struct {
+	// SplitRoutes is set when clients talk to mcrouter.  Routing prefixes
+	// such as /region/cluster/ are then reported in the Route field of events
+	// instead of as part of the key, so that the same key aggregates together
+	// regardless of route, and introspection commands are reported as
+	// EventIntrospect.
+	SplitRoutes bool
+	// KeySlots is set to report the hash slot of each key, for datastores
+	// that shard keys by slot.  Slots are left zero otherwise, sparing a
+	// checksum of every key.
+	KeySlots bool
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]*Protocol)
+)
+
+// Register makes a protocol available by name.  It panics if the name is
+// empty or already registered.
+func Register(p Protocol) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if p.Name == "" || p.NewFsm == nil {
+		panic("model: invalid protocol registration")
+	}
+	if _, dup := registry[p.Name]; dup {
+		panic(fmt.Sprintf("model: protocol %s registered twice", p.Name))
+	}
+	registry[p.Name] = &p
+}
+
+// LookupProtocol returns the protocol registered under name, or nil if there
+// is none.
+func LookupProtocol(name string) *Protocol {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return registry[name]
+}
+
+// Protocols returns all registered protocols, ordered by name.
+func Protocols() []*Protocol {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	ps := make([]*Protocol, 0, len(registry))
+	for _, p := range registry {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Name < ps[j].Name })
+	return ps
+}
+
+// ProtocolNames returns the names of all registered protocols in order.
+func ProtocolNames() []string {
+	ps := Protocols()
+	names := make([]string, len(ps))
+	for i, p := range ps {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// DefaultPorts returns the ports of all registered protocols in ascending
+// order, without duplicates.
+func DefaultPorts() []int {
+	seen := make(map[int]bool)
+	var ports []int
+	for _, p := range Protocols() {
+		for _, port := range p.Ports {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// ParsePorts parses port specifications of the form "port" or
+// "port=protocol".  It returns all the ports, and the protocol for each port
+// that specified one.  Each port may be listed only once.
+func ParsePorts(specs []string) ([]int, map[int]*Protocol, error) {
+	ports := make([]int, 0, len(specs))
+	protocols := make(map[int]*Protocol)
+	seen := make(map[int]bool)
+	for _, spec := range specs {
+		portStr, protocolName, hasProtocol := spec, "", false
+		if i := strings.IndexByte(spec, '='); i >= 0 {
+			portStr, protocolName, hasProtocol = spec[:i], spec[i+1:], true
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, nil, fmt.Errorf("invalid port: %s", spec)
+		}
+		if seen[port] {
+			return nil, nil, fmt.Errorf("port listed twice: %s", spec)
+		}
+		seen[port] = true
+		ports = append(ports, port)
+		if hasProtocol {
+			protocol := LookupProtocol(protocolName)
+			if protocol == nil {
+				return nil, nil, fmt.Errorf("unknown protocol: %s", spec)
+			}
+			protocols[port] = protocol
+		}
+	}
+	return ports, protocols, nil
+}
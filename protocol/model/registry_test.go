@@ -0,0 +1,78 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/box/memsniff/log"
+)
+
+func init() {
+	for _, name := range []string{"mctext", "mcbinary", "redis"} {
+		Register(Protocol{Name: name, NewFsm: func(log.Logger, Options) Fsm { return noopFsm{} }})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register(Protocol{
+		Name:   "testproxy",
+		NewFsm: func(log.Logger, Options) Fsm { return noopFsm{} },
+		Ports:  []int{7000, 6379},
+	})
+	p := LookupProtocol("testproxy")
+	if p == nil || p.Name != "testproxy" {
+		t.Fatal(p)
+	}
+	if LookupProtocol("nosuch") != nil {
+		t.Error("found unregistered protocol")
+	}
+	if names := ProtocolNames(); !reflect.DeepEqual(names, []string{"mcbinary", "mctext", "redis", "testproxy"}) {
+		t.Error(names)
+	}
+	if ports := DefaultPorts(); !reflect.DeepEqual(ports, []int{6379, 7000}) {
+		t.Error(ports)
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	Register(Protocol{Name: "redis", NewFsm: func(log.Logger, Options) Fsm { return noopFsm{} }})
+}
+
+func TestParsePorts(t *testing.T) {
+	ports, protocols, err := ParsePorts([]string{"11211=mctext", "6379=redis", "11212=mcbinary", "9000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ports, []int{11211, 6379, 11212, 9000}) {
+		t.Error(ports)
+	}
+	expected := map[int]*Protocol{
+		11211: LookupProtocol("mctext"),
+		6379:  LookupProtocol("redis"),
+		11212: LookupProtocol("mcbinary"),
+	}
+	if !reflect.DeepEqual(protocols, expected) {
+		t.Error(protocols)
+	}
+}
+
+func TestParsePortsInvalid(t *testing.T) {
+	for _, spec := range []string{"abc", "0", "70000", "11211=nosuch", "=redis"} {
+		if _, _, err := ParsePorts([]string{spec}); err == nil {
+			t.Error("expected error for", spec)
+		}
+	}
+}
+
+func TestParsePortsDuplicate(t *testing.T) {
+	for _, specs := range [][]string{{"11211=mctext", "11211=redis"}, {"9000", "9000"}} {
+		if _, _, err := ParsePorts(specs); err == nil {
+			t.Error("expected error for", specs)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package redis
+
+import (
+	"strings"
+
+	"github.com/box/memsniff/protocol/model"
+)
+
+// numSlots is the number of hash slots in a Redis Cluster.
+const numSlots = 16384
+
+// crc16Table is the lookup table for CRC16-CCITT (XMODEM), the checksum
+// used by Redis Cluster to assign keys to hash slots.
+var crc16Table [256]uint16
+
+func init() {
+	for i := range crc16Table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+// KeySlot returns the Redis Cluster hash slot of key.  If the key contains a
+// non-empty hash tag between braces, only the hash tag is hashed, so that
+// related keys can be kept in the same slot.
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % numSlots)
+}
+
+// parseRedirect interprets a MOVED or ASK error reply, of the form
+// "MOVED <slot> <host>:<port>".
+func parseRedirect(err error) (outcome model.Outcome, target string, ok bool) {
+	fields := strings.Fields(err.Error())
+	if len(fields) != 3 {
+		return model.OutcomeNone, "", false
+	}
+	switch fields[0] {
+	case "MOVED":
+		return model.OutcomeMoved, fields[2], true
+	case "ASK":
+		return model.OutcomeAsk, fields[2], true
+	default:
+		return model.OutcomeNone, "", false
+	}
+}
+
+// handleRedirect records a redirect event for each key of a command sent to
+// a cluster node that does not serve the key's slot.  It returns false if
+// res is not a redirection.
+func (f *fsm) handleRedirect(cmd command, res interface{}) bool {
+	err, isErr := res.(error)
+	if !isErr {
+		return false
+	}
+	outcome, target, ok := parseRedirect(err)
+	if !ok {
+		return false
+	}
+	keys := cmd.keys
+	if len(keys) == 0 {
+		keys = []string{""}
+	}
+	for _, key := range keys {
+		f.addEvent(cmd, model.Event{
+			Type:    model.EventRedirect,
+			Key:     key,
+			Outcome: outcome,
+			Target:  target,
+		})
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/protocol/model"
+)
+
+func TestKeySlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"123456789", 0x31c3},
+		{"foo", 12182},
+		{"{foo}.bar", 12182},
+		{"foo{}{bar}", KeySlot("foo{}{bar}")},
+		{"foo{{bar}}zap", KeySlot("{bar")},
+		{"foo{bar}{zap}", KeySlot("bar")},
+	}
+	for _, tt := range tests {
+		if slot := KeySlot(tt.key); slot != tt.slot {
+			t.Error(tt.key, "expected", tt.slot, "got", slot)
+		}
+	}
+	if KeySlot("foo{}{bar}") == KeySlot("bar") {
+		t.Error("empty hash tag should hash the whole key")
+	}
+}
+
+func TestEventSlot(t *testing.T) {
+	var evts []model.Event
+	f := NewFsm(log.ConsoleLogger{})
+	f.slots = true
+	c := model.New(func(e []model.Event) { evts = append(evts, e...) }, f)
+	c.ClientStream().Reassembled(reassemblyString(resp("get", "foo")))
+	c.ServerStream().Reassembled(reassemblyString("$3\r\nbar\r\n"))
+	c.ClientStream().ReassemblyComplete()
+	c.ServerStream().ReassemblyComplete()
+
+	if len(evts) != 1 || evts[0].Slot != 12182 {
+		t.Error(evts)
+	}
+}
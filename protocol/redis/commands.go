@@ -0,0 +1,193 @@
+package redis
+
+import "github.com/box/memsniff/protocol/model"
+
+// commandSpec describes the arguments of a Redis command, using the key
+// positions reported by the Redis COMMAND command.
+type commandSpec struct {
+	// firstKey is the position of the first key argument, or zero if the
+	// command takes no keys.
+	firstKey int
+	// lastKey is the position of the last key argument.  Negative values
+	// count back from the final argument, so -1 is the final argument.
+	lastKey int
+	// step is the distance between key arguments.
+	step int
+	// write is set if the command may modify data.
+	write bool
+	// evtType is the type of event produced by the command, or EventUnknown
+	// to use EventRead or EventWrite.
+	evtType model.EventType
+	// valueOffset is the distance from each key argument to the value stored
+	// under that key, or zero if there is none.
+	valueOffset int
+}
+
+// readSpec describes a command that reads the keys from first to last.
+func readSpec(first, last, step int) commandSpec {
+	return commandSpec{firstKey: first, lastKey: last, step: step}
+}
+
+// writeSpec describes a command that modifies the keys from first to last.
+func writeSpec(first, last, step int) commandSpec {
+	return commandSpec{firstKey: first, lastKey: last, step: step, write: true}
+}
+
+// typedSpec describes a command that produces events of evtType for the keys
+// from first to last.
+func typedSpec(evtType model.EventType, first, last, step, valueOffset int) commandSpec {
+	return commandSpec{
+		firstKey:    first,
+		lastKey:     last,
+		step:        step,
+		write:       evtType != model.EventGetHit,
+		evtType:     evtType,
+		valueOffset: valueOffset,
+	}
+}
+
+var commands = map[string]commandSpec{
+	// strings
+	"get":         typedSpec(model.EventGetHit, 1, 1, 1, 0),
+	"mget":        typedSpec(model.EventGetHit, 1, -1, 1, 0),
+	"getrange":    readSpec(1, 1, 1),
+	"strlen":      readSpec(1, 1, 1),
+	"getex":       writeSpec(1, 1, 1),
+	"getdel":      writeSpec(1, 1, 1),
+	"set":         typedSpec(model.EventSet, 1, 1, 1, 1),
+	"setex":       typedSpec(model.EventSet, 1, 1, 1, 2),
+	"psetex":      typedSpec(model.EventSet, 1, 1, 1, 2),
+	"getset":      typedSpec(model.EventSet, 1, 1, 1, 1),
+	"mset":        typedSpec(model.EventSet, 1, -1, 2, 1),
+	"setnx":       typedSpec(model.EventAdd, 1, 1, 1, 1),
+	"msetnx":      typedSpec(model.EventAdd, 1, -1, 2, 1),
+	"setrange":    writeSpec(1, 1, 1),
+	"append":      typedSpec(model.EventAppend, 1, 1, 1, 1),
+	"incr":        typedSpec(model.EventIncr, 1, 1, 1, 0),
+	"incrby":      typedSpec(model.EventIncr, 1, 1, 1, 0),
+	"incrbyfloat": typedSpec(model.EventIncr, 1, 1, 1, 0),
+	"decr":        typedSpec(model.EventDecr, 1, 1, 1, 0),
+	"decrby":      typedSpec(model.EventDecr, 1, 1, 1, 0),
+
+	// keyspace
+	"del":       typedSpec(model.EventDelete, 1, -1, 1, 0),
+	"unlink":    typedSpec(model.EventDelete, 1, -1, 1, 0),
+	"exists":    readSpec(1, -1, 1),
+	"type":      readSpec(1, 1, 1),
+	"ttl":       readSpec(1, 1, 1),
+	"pttl":      readSpec(1, 1, 1),
+	"expire":    typedSpec(model.EventTouch, 1, 1, 1, 0),
+	"pexpire":   typedSpec(model.EventTouch, 1, 1, 1, 0),
+	"expireat":  typedSpec(model.EventTouch, 1, 1, 1, 0),
+	"pexpireat": typedSpec(model.EventTouch, 1, 1, 1, 0),
+	"persist":   typedSpec(model.EventTouch, 1, 1, 1, 0),
+	"rename":    writeSpec(1, 2, 1),
+	"renamenx":  writeSpec(1, 2, 1),
+	"flushdb":   typedSpec(model.EventFlush, 0, 0, 0, 0),
+	"flushall":  typedSpec(model.EventFlush, 0, 0, 0, 0),
+
+	// hashes
+	"hget":         readSpec(1, 1, 1),
+	"hmget":        readSpec(1, 1, 1),
+	"hgetall":      readSpec(1, 1, 1),
+	"hkeys":        readSpec(1, 1, 1),
+	"hvals":        readSpec(1, 1, 1),
+	"hlen":         readSpec(1, 1, 1),
+	"hexists":      readSpec(1, 1, 1),
+	"hstrlen":      readSpec(1, 1, 1),
+	"hscan":        readSpec(1, 1, 1),
+	"hrandfield":   readSpec(1, 1, 1),
+	"hset":         writeSpec(1, 1, 1),
+	"hmset":        writeSpec(1, 1, 1),
+	"hsetnx":       writeSpec(1, 1, 1),
+	"hdel":         writeSpec(1, 1, 1),
+	"hincrby":      writeSpec(1, 1, 1),
+	"hincrbyfloat": writeSpec(1, 1, 1),
+
+	// lists
+	"lrange":    readSpec(1, 1, 1),
+	"lindex":    readSpec(1, 1, 1),
+	"llen":      readSpec(1, 1, 1),
+	"lpos":      readSpec(1, 1, 1),
+	"lpush":     writeSpec(1, 1, 1),
+	"rpush":     writeSpec(1, 1, 1),
+	"lpushx":    writeSpec(1, 1, 1),
+	"rpushx":    writeSpec(1, 1, 1),
+	"lpop":      writeSpec(1, 1, 1),
+	"rpop":      writeSpec(1, 1, 1),
+	"lset":      writeSpec(1, 1, 1),
+	"lrem":      writeSpec(1, 1, 1),
+	"ltrim":     writeSpec(1, 1, 1),
+	"linsert":   writeSpec(1, 1, 1),
+	"rpoplpush": writeSpec(1, 2, 1),
+	"lmove":     writeSpec(1, 2, 1),
+	"blpop":     writeSpec(1, -2, 1),
+	"brpop":     writeSpec(1, -2, 1),
+
+	// sets
+	"smembers":    readSpec(1, 1, 1),
+	"sismember":   readSpec(1, 1, 1),
+	"smismember":  readSpec(1, 1, 1),
+	"scard":       readSpec(1, 1, 1),
+	"srandmember": readSpec(1, 1, 1),
+	"sscan":       readSpec(1, 1, 1),
+	"sinter":      readSpec(1, -1, 1),
+	"sunion":      readSpec(1, -1, 1),
+	"sdiff":       readSpec(1, -1, 1),
+	"sadd":        writeSpec(1, 1, 1),
+	"srem":        writeSpec(1, 1, 1),
+	"spop":        writeSpec(1, 1, 1),
+	"smove":       writeSpec(1, 2, 1),
+	"sinterstore": writeSpec(1, -1, 1),
+	"sunionstore": writeSpec(1, -1, 1),
+	"sdiffstore":  writeSpec(1, -1, 1),
+
+	// sorted sets
+	"zrange":           readSpec(1, 1, 1),
+	"zrangebyscore":    readSpec(1, 1, 1),
+	"zrangebylex":      readSpec(1, 1, 1),
+	"zrevrange":        readSpec(1, 1, 1),
+	"zrevrangebyscore": readSpec(1, 1, 1),
+	"zrevrangebylex":   readSpec(1, 1, 1),
+	"zscore":           readSpec(1, 1, 1),
+	"zmscore":          readSpec(1, 1, 1),
+	"zrank":            readSpec(1, 1, 1),
+	"zrevrank":         readSpec(1, 1, 1),
+	"zcard":            readSpec(1, 1, 1),
+	"zcount":           readSpec(1, 1, 1),
+	"zlexcount":        readSpec(1, 1, 1),
+	"zscan":            readSpec(1, 1, 1),
+	"zrandmember":      readSpec(1, 1, 1),
+	"zadd":             writeSpec(1, 1, 1),
+	"zincrby":          writeSpec(1, 1, 1),
+	"zrem":             writeSpec(1, 1, 1),
+	"zremrangebyrank":  writeSpec(1, 1, 1),
+	"zremrangebyscore": writeSpec(1, 1, 1),
+	"zremrangebylex":   writeSpec(1, 1, 1),
+	"zpopmin":          writeSpec(1, 1, 1),
+	"zpopmax":          writeSpec(1, 1, 1),
+}
+
+// keyPositions returns the positions of the key arguments in a command with
+// n arguments, including the command name.
+func (s commandSpec) keyPositions(n int) []int {
+	if s.firstKey <= 0 || s.firstKey >= n {
+		return nil
+	}
+	last := s.lastKey
+	if last < 0 {
+		last += n
+	}
+	if last >= n {
+		last = n - 1
+	}
+	step := s.step
+	if step < 1 {
+		step = 1
+	}
+	var positions []int
+	for i := s.firstKey; i <= last; i += step {
+		positions = append(positions, i)
+	}
+	return positions
+}
@@ -0,0 +1,28 @@
+package redis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeyPositions(t *testing.T) {
+	tests := []struct {
+		cmd      string
+		nargs    int
+		expected []int
+	}{
+		{"get", 2, []int{1}},
+		{"get", 1, nil},
+		{"mget", 4, []int{1, 2, 3}},
+		{"mset", 5, []int{1, 3}},
+		{"blpop", 4, []int{1, 2}},
+		{"smove", 4, []int{1, 2}},
+		{"flushdb", 1, nil},
+	}
+	for _, tt := range tests {
+		positions := commands[tt.cmd].keyPositions(tt.nargs)
+		if !reflect.DeepEqual(positions, tt.expected) {
+			t.Error(tt.cmd, tt.nargs, "expected", tt.expected, "got", positions)
+		}
+	}
+}
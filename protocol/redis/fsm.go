@@ -2,7 +2,9 @@ package redis
 
 import (
 	"io"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/box/memsniff/assembly/reader"
 	"github.com/box/memsniff/log"
@@ -11,28 +13,121 @@ import (
 
 const (
 	maxCommandSize = 1024
+	debuglevel     = 0
+	// maxPending limits the number of commands awaiting a reply, to bound
+	// memory used by connections where replies are lost.
+	maxPending = 1024
 )
 
 type state func() error
 
+// command is a client request awaiting a reply from the server.
+type command struct {
+	// handleReply records events based on the server reply to the command,
+	// or is nil if the command has no reply of its own.
+	handleReply func(cmd command, res interface{}, size int)
+	// name is the lower case command name.
+	name string
+	keys []string
+	// sizes holds the size of the value written under each key.
+	sizes   []int
+	evtType model.EventType
+	// protover is the protocol version requested by HELLO.
+	protover int
+	// db and user are the database and user in effect when the command was sent.
+	db   int
+	user string
+	// execReply handles the result of a command queued in a transaction,
+	// which is part of the reply to EXEC.
+	execReply func(cmd command, res interface{}, size int)
+	// script identifies the script or function run by the command.
+	script string
+	// start is the capture time of the first byte of the command.
+	start time.Time
+}
+
 type fsm struct {
-	logger   log.Logger
-	consumer *model.Consumer
-	state    state
-	parser   *RespParser
+	logger       log.Logger
+	consumer     *model.Consumer
+	state        state
+	clientParser *RespParser
+	serverParser *RespParser
+	// pending holds commands awaiting a reply, in the order they were sent.
+	pending []command
+	// start is the capture time of the first byte of the command being read.
+	start time.Time
+	// protover is the RESP version in use on the connection.
+	protover int
+	// db is the database chosen with SELECT.
+	db int
+	// user is the user authenticated with AUTH or HELLO.
+	user string
+	// inMulti is set while commands are being queued for a transaction.
+	inMulti bool
+	// queued holds commands accepted by the server for the current transaction.
+	queued []command
+	// subscribed is set while the client is subscribed to pub/sub channels.
+	subscribed bool
+	// monitor is set once the client has issued MONITOR.
+	monitor bool
+	// slots is set to report the hash slot of each key.
+	slots bool
+}
+
+func init() {
+	model.Register(model.Protocol{
+		Name:   "redis",
+		NewFsm: newConfiguredFsm,
+		Ports:  []int{6379},
+		Sniff:  sniff,
+	})
+}
+
+// newConfiguredFsm returns an Fsm adjusted by opts.
+func newConfiguredFsm(logger log.Logger, opts model.Options) model.Fsm {
+	f := NewFsm(logger)
+	f.slots = opts.KeySlots
+	return f
+}
+
+// sniff recognizes RESP arrays from the client and RESP replies from the
+// server.  Inline commands are indistinguishable from memcached text commands
+// until the server replies.
+func sniff(client, server []byte) float64 {
+	if len(server) > 0 {
+		if IsReplyTag(server[0]) {
+			return 1
+		}
+		return 0
+	}
+	if len(client) == 0 {
+		return 0
+	}
+	if client[0] == tagArray {
+		return 1
+	}
+	if ('a' <= client[0] && client[0] <= 'z') || ('A' <= client[0] && client[0] <= 'Z') {
+		return 0.5
+	}
+	return 0
 }
 
 func NewFsm(logger log.Logger) *fsm {
 	f := &fsm{
-		logger: logger,
-		parser: NewParser(nil),
+		logger:       logger,
+		clientParser: NewParser(nil),
+		serverParser: NewParser(nil),
+		protover:     2,
 	}
+	f.clientParser.Options.BulkCaptureLimit = maxCommandSize
+	f.clientParser.Options.InlineCommands = true
+	f.serverParser.Options.BulkCaptureLimit = 0
 	return f
 }
 
 func (f *fsm) SetConsumer(consumer *model.Consumer) {
 	f.consumer = consumer
-	f.transitionTo(false, f.readCommand)
+	f.reset()
 }
 
 func (f *fsm) Run() {
@@ -46,79 +141,472 @@ func (f *fsm) Run() {
 		default:
 			f.consumer.ClientReader.Reset()
 			f.consumer.ServerReader.Reset()
-			f.transitionTo(false, f.readCommand)
+			f.reset()
 			return
 		}
 	}
 }
 
-func (f *fsm) transitionTo(fromServer bool, state state) {
-	if fromServer {
-		f.parser.Reset(f.consumer.ServerReader)
-		f.parser.Options.BulkCaptureLimit = 0
-	} else {
-		f.parser.Reset(f.consumer.ClientReader)
-		f.parser.Options.BulkCaptureLimit = maxCommandSize
-	}
-	f.state = state
+// reset discards all pending commands and partially parsed data, and prepares
+// to read the next command.
+func (f *fsm) reset() {
+	f.pending = f.pending[:0]
+	f.inMulti = false
+	f.queued = nil
+	f.serverParser.Reset(f.consumer.ServerReader)
+	f.startCommand()
+}
+
+// startCommand prepares to read the next command from the client.
+func (f *fsm) startCommand() {
+	f.clientParser.Reset(f.consumer.ClientReader)
+	f.start = time.Time{}
+	f.state = f.readCommand
 }
 
 func (f *fsm) readCommand() error {
-	f.consumer.ServerReader.Truncate()
-	err := f.parser.Run()
+	if len(f.pending) == 0 {
+		if _, err := f.consumer.ClientReader.PeekN(1); err != nil {
+			if f.protover >= 3 || f.subscribed {
+				if _, err := f.consumer.ServerReader.PeekN(1); err == nil {
+					// the server may send push messages at any time
+					f.state = f.readReply
+					return nil
+				}
+			} else {
+				// nothing outstanding, so any server data is not a reply we
+				// can interpret
+				f.consumer.ServerReader.Truncate()
+				f.serverParser.Reset(f.consumer.ServerReader)
+			}
+		}
+	}
+	if f.start.IsZero() {
+		f.start = f.consumer.ClientReader.Seen()
+	}
+	err := f.clientParser.Run()
+	if err == reader.ErrShortRead && len(f.pending) > 0 {
+		// process replies to earlier commands while the rest of this one
+		// arrives
+		f.state = f.readReply
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	fields := f.parser.BulkArray()
-	cmd := fields[0]
-	switch strings.ToLower(string(cmd)) {
-	case "get", "mget":
-		if len(fields) < 2 {
-			return ProtocolErr
-		}
-		f.transitionTo(true, f.handleGet(fields[1]))
+	args, ok := f.clientParser.Result().([]interface{})
+	if !ok {
+		return ProtocolErr
+	}
+	if len(args) == 0 {
+		// blank inline command
+		f.startCommand()
+		return nil
+	}
+	fields := f.clientParser.BulkArray()
+	cmd := f.parseCommand(fields, args)
+	if f.monitor {
+		f.log(2, "connection is in MONITOR mode, ignoring")
+		f.state = f.ignore
 		return nil
+	}
+	if cmd.handleReply != nil {
+		f.addPending(cmd)
+	}
+
+	f.startCommand()
+	if _, err := f.consumer.ClientReader.PeekN(1); err != nil {
+		// no more pipelined commands yet, wait for replies
+		f.state = f.readReply
+	}
+	return nil
+}
+
+// parseCommand determines how to handle the reply to the command in fields.
+func (f *fsm) parseCommand(fields [][]byte, args []interface{}) command {
+	cmd := command{
+		handleReply: f.discardReply,
+		db:          f.db,
+		user:        f.user,
+		start:       f.start,
+	}
+	name := strings.ToLower(string(fields[0]))
+	cmd.name = name
+	switch name {
+	case "multi", "exec", "discard":
+		return f.parseTransaction(cmd, name)
+	}
+	cmd = f.parseSingleCommand(cmd, name, fields, args)
+	if f.inMulti {
+		// the reply is only QUEUED, results arrive with EXEC
+		cmd.execReply = cmd.handleReply
+		cmd.handleReply = f.handleQueued
+	}
+	return cmd
+}
+
+// parseSingleCommand determines how to handle the reply to a command that
+// is not part of transaction control.
+func (f *fsm) parseSingleCommand(cmd command, name string, fields [][]byte, args []interface{}) command {
+	// session changes apply to pipelined commands sent before the reply, so
+	// take effect immediately and are reverted if the server rejects them
+	switch name {
+	case "select":
+		if len(fields) >= 2 {
+			if db, err := strconv.Atoi(string(fields[1])); err == nil {
+				cmd.handleReply = f.handleSession
+				f.db = db
+			}
+		}
+		return cmd
+	case "auth":
+		switch len(fields) {
+		case 2:
+			cmd.handleReply = f.handleSession
+			f.user = "default"
+		case 3:
+			cmd.handleReply = f.handleSession
+			f.user = string(fields[1])
+		}
+		return cmd
+	case "hello":
+		cmd.handleReply = f.handleHello
+		if len(fields) >= 2 {
+			cmd.protover, _ = strconv.Atoi(string(fields[1]))
+		}
+		for i := 2; i+2 < len(fields); i++ {
+			if strings.EqualFold(string(fields[i]), "auth") {
+				f.user = string(fields[i+1])
+				break
+			}
+		}
+		return cmd
+	case "eval", "eval_ro", "evalsha", "evalsha_ro", "fcall", "fcall_ro":
+		return f.parseScript(cmd, name, fields, args)
+	case "subscribe", "psubscribe", "ssubscribe", "unsubscribe", "punsubscribe", "sunsubscribe",
+		"publish", "spublish", "monitor":
+		return f.parsePubSub(cmd, name, fields, args)
+	}
+	spec, ok := commands[name]
+	if !ok {
+		return cmd
+	}
+	positions := spec.keyPositions(len(fields))
+	if spec.evtType == model.EventFlush {
+		// affects all keys
+		cmd.handleReply = f.handleWrite
+		cmd.evtType = spec.evtType
+		cmd.keys = []string{""}
+		cmd.sizes = []int{0}
+		return cmd
+	}
+	if len(positions) == 0 {
+		return cmd
+	}
+	cmd.keys = make([]string, len(positions))
+	for i, pos := range positions {
+		cmd.keys[i] = string(fields[pos])
+	}
+
+	cmd.evtType = spec.evtType
+	switch spec.evtType {
+	case model.EventGetHit:
+		cmd.handleReply = f.handleGet
+	case model.EventDelete:
+		cmd.handleReply = f.handleDelete
+	case model.EventUnknown:
+		if spec.write {
+			cmd.handleReply = f.handleWrite
+			cmd.evtType = model.EventWrite
+			cmd.sizes = splitSize(argsSize(args, positions), len(positions))
+		} else {
+			cmd.handleReply = f.handleRead
+			cmd.evtType = model.EventRead
+		}
 	default:
-		f.transitionTo(true, f.discardResponse)
+		cmd.handleReply = f.handleWrite
+		cmd.sizes = make([]int, len(positions))
+		if spec.valueOffset > 0 {
+			for i, pos := range positions {
+				if pos+spec.valueOffset < len(args) {
+					cmd.sizes[i] = bulkSize(args[pos+spec.valueOffset])
+				}
+			}
+		}
+	}
+	return cmd
+}
+
+// argsSize returns the total size of the arguments of a command, excluding
+// the command name and the keys at positions.
+func argsSize(args []interface{}, positions []int) int {
+	var size int
+	for i := 1; i < len(args); i++ {
+		size += bulkSize(args[i])
+	}
+	for _, pos := range positions {
+		size -= bulkSize(args[pos])
+	}
+	return size
+}
+
+// splitSize divides size evenly between n keys, since the protocol does not
+// attribute data in multi-key commands to individual keys.
+func splitSize(size int, n int) []int {
+	sizes := make([]int, n)
+	for i := range sizes {
+		sizes[i] = size / n
+	}
+	return sizes
+}
+
+func (f *fsm) addPending(cmd command) {
+	if len(f.pending) >= maxPending {
+		f.log(2, "too many pending commands, discarding oldest")
+		f.pending = f.pending[1:]
+	}
+	f.pending = append(f.pending, cmd)
+}
+
+func (f *fsm) readReply() error {
+	err := f.serverParser.Run()
+	if err != nil {
+		return err
+	}
+	res := f.serverParser.Result()
+	size := f.serverParser.Size()
+	if push, ok := res.(Push); ok {
+		// out-of-band data, not a reply to any pending command.  Only RESP3
+		// has push messages, even if HELLO was not captured.
+		f.protover = 3
+		f.handlePush(push)
+	} else if f.isPubSubMessage(res) {
+		// RESP2 subscribers receive messages as arrays
+		f.handlePush(res.([]interface{}))
+	} else if len(f.pending) == 0 {
+		f.log(3, "discarding unsolicited reply")
+	} else {
+		cmd := f.pending[0]
+		f.pending = f.pending[1:]
+		if !f.handleRedirect(cmd, res) {
+			cmd.handleReply(cmd, res, size)
+			f.handleError(cmd, res)
+		}
+	}
+	f.serverParser.Reset(f.consumer.ServerReader)
+	if len(f.pending) == 0 {
+		f.state = f.readCommand
 	}
 	return nil
 }
 
-func (f *fsm) handleGet(key []byte) func() error {
-	return func() error {
-		err := f.parser.Run()
-		if err != nil {
-			return err
+// handleGet records a hit or miss for each key of GET or MGET.
+func (f *fsm) handleGet(cmd command, res interface{}, size int) {
+	values, ok := res.([]interface{})
+	if !ok {
+		// single bulk reply to GET
+		values = []interface{}{res}
+	}
+	for i, key := range cmd.keys {
+		if i >= len(values) {
+			break
 		}
-		res := f.parser.Result()
-		if res == nil {
-			f.consumer.AddEvent(model.Event{
+		switch v := values[i].(type) {
+		case nil:
+			f.addEvent(cmd, model.Event{
 				Type: model.EventGetMiss,
-				Key:  string(key),
+				Key:  key,
 			})
-		} else {
-			f.consumer.AddEvent(model.Event{
+		case error:
+		default:
+			f.addEvent(cmd, model.Event{
 				Type: model.EventGetHit,
-				Key:  string(key),
-				Size: res.(int),
+				Key:  key,
+				Size: bulkSize(v),
 			})
 		}
-		f.transitionTo(false, f.readCommand)
-		return nil
 	}
 }
 
-func (f *fsm) discardResponse() error {
-	err := f.parser.Run()
-	if err != nil {
-		return err
+// handleRead records the reply size for each key of a generic read command.
+func (f *fsm) handleRead(cmd command, res interface{}, size int) {
+	if _, isErr := res.(error); isErr {
+		return
 	}
-	f.transitionTo(false, f.readCommand)
-	return nil
+	sizes := splitSize(size, len(cmd.keys))
+	for i, key := range cmd.keys {
+		f.addEvent(cmd, model.Event{
+			Type: cmd.evtType,
+			Key:  key,
+			Size: sizes[i],
+		})
+	}
+}
+
+// handleWrite records the size written to each key of a write command.
+func (f *fsm) handleWrite(cmd command, res interface{}, size int) {
+	if _, isErr := res.(error); isErr {
+		return
+	}
+	outcome := writeOutcome(cmd.evtType, res)
+	for i, key := range cmd.keys {
+		f.addEvent(cmd, model.Event{
+			Type:    cmd.evtType,
+			Key:     key,
+			Size:    cmd.sizes[i],
+			Outcome: outcome,
+		})
+	}
+}
+
+func (f *fsm) handleDelete(cmd command, res interface{}, size int) {
+	n, ok := res.(int)
+	if !ok {
+		return
+	}
+	// only the number of keys deleted is reported, so the outcome
+	// is only known if all or none were deleted
+	outcome := model.OutcomeNone
+	if n == len(cmd.keys) {
+		outcome = model.OutcomeDeleted
+	} else if n == 0 {
+		outcome = model.OutcomeNotFound
+	}
+	for _, key := range cmd.keys {
+		f.addEvent(cmd, model.Event{
+			Type:    model.EventDelete,
+			Key:     key,
+			Outcome: outcome,
+		})
+	}
+}
+
+// command returns the name of the operation performed by cmd, as returned by
+// EventType.Command, or the empty string if unknown.
+func (cmd command) command() string {
+	if cmd.evtType == model.EventUnknown {
+		return ""
+	}
+	return cmd.evtType.Command()
+}
+
+// handleError records an error event for each key of a command that the
+// server rejected.
+func (f *fsm) handleError(cmd command, res interface{}) {
+	err, isErr := res.(error)
+	if !isErr {
+		return
+	}
+	keys := cmd.keys
+	if len(keys) == 0 {
+		keys = []string{""}
+	}
+	class := errorClass(err)
+	for _, key := range keys {
+		f.addEvent(cmd, model.Event{
+			Type:    model.EventError,
+			Key:     key,
+			Error:   class,
+			Command: cmd.command(),
+		})
+	}
+}
+
+// errorClass returns the error code at the start of a Redis error message,
+// such as ERR or WRONGTYPE.
+func errorClass(err error) string {
+	msg := err.Error()
+	if i := strings.IndexByte(msg, ' '); i >= 0 {
+		return msg[:i]
+	}
+	return msg
+}
+
+// handleSession reverts a change to the database or user if it was rejected
+// by the server.
+func (f *fsm) handleSession(cmd command, res interface{}, size int) {
+	if _, isErr := res.(error); isErr {
+		f.db = cmd.db
+		f.user = cmd.user
+	}
+}
+
+// handleHello records the protocol version negotiated by HELLO.
+func (f *fsm) handleHello(cmd command, res interface{}, size int) {
+	if _, isErr := res.(error); isErr {
+		f.user = cmd.user
+		return
+	}
+	if cmd.protover != 0 {
+		f.protover = cmd.protover
+	}
+}
+
+func (f *fsm) discardReply(cmd command, res interface{}, size int) {}
+
+// writeOutcome determines the outcome of a write command from the server response.
+func writeOutcome(evtType model.EventType, res interface{}) model.Outcome {
+	if evtType == model.EventWrite || evtType == model.EventPublish {
+		// replies to data structure commands vary too much to interpret, and
+		// PUBLISH replies with the number of receivers
+		return model.OutcomeNone
+	}
+	switch r := res.(type) {
+	case nil:
+		// SET with NX or XX did not find the key in the required state
+		return model.OutcomeNotStored
+	case string:
+		if evtType == model.EventFlush {
+			return model.OutcomeOK
+		}
+		return model.OutcomeStored
+	case int:
+		switch evtType {
+		case model.EventAdd:
+			if r == 1 {
+				return model.OutcomeStored
+			}
+			return model.OutcomeNotStored
+		case model.EventTouch:
+			if r == 1 {
+				return model.OutcomeTouched
+			}
+			return model.OutcomeNotFound
+		case model.EventIncr, model.EventDecr:
+			return model.OutcomeOK
+		}
+	}
+	// reply contains the new length or the previous value
+	return model.OutcomeStored
+}
+
+// bulkSize returns the length of a bulk string, which the parser either
+// captures or reports as its length if larger than BulkCaptureLimit.
+func bulkSize(v interface{}) int {
+	switch b := v.(type) {
+	case []byte:
+		return len(b)
+	case int:
+		return b
+	default:
+		return 0
+	}
+}
+
+// addEvent records evt as a result of cmd, with latency measured up to the
+// end of the response.
+func (f *fsm) addEvent(cmd command, evt model.Event) {
+	evt.DB = cmd.db
+	evt.User = cmd.user
+	evt.Script = cmd.script
+	if f.slots && evt.Key != "" {
+		evt.Slot = KeySlot(evt.Key)
+	}
+	f.consumer.AddResponseEvent(evt, cmd.start)
 }
 
-func (f *fsm) log(items ...interface{}) {
-	if f.logger != nil {
+func (f *fsm) log(level int, items ...interface{}) {
+	if f.logger != nil && debuglevel >= level {
 		f.logger.Log(items...)
 	}
 }
@@ -3,7 +3,9 @@ package redis
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/box/memsniff/log"
 	"github.com/box/memsniff/protocol/model"
@@ -64,6 +66,261 @@ func TestIgnoreUnknown(t *testing.T) {
 	test(t, input, output, expected)
 }
 
+func TestWrites(t *testing.T) {
+	exchanges := [][2]string{
+		{resp("SET", "key1", "hello"), "+OK\r\n"},
+		{resp("SETNX", "key2", "abc"), ":0\r\n"},
+		{resp("INCRBY", "key3", "5"), ":15\r\n"},
+		{resp("EXPIRE", "key4", "60"), ":1\r\n"},
+		{resp("DEL", "key5", "key6"), ":2\r\n"},
+		{resp("SET", "key7", "x", "XX"), "$-1\r\n"},
+		{resp("FLUSHDB"), "+OK\r\n"},
+	}
+	expected := []model.Event{
+		{Type: model.EventSet, Key: "key1", Size: 5, Outcome: model.OutcomeStored},
+		{Type: model.EventAdd, Key: "key2", Size: 3, Outcome: model.OutcomeNotStored},
+		{Type: model.EventIncr, Key: "key3", Outcome: model.OutcomeOK},
+		{Type: model.EventTouch, Key: "key4", Outcome: model.OutcomeTouched},
+		{Type: model.EventDelete, Key: "key5", Outcome: model.OutcomeDeleted},
+		{Type: model.EventDelete, Key: "key6", Outcome: model.OutcomeDeleted},
+		{Type: model.EventSet, Key: "key7", Size: 1, Outcome: model.OutcomeNotStored},
+		{Type: model.EventFlush, Outcome: model.OutcomeOK},
+	}
+	testExchanges(t, exchanges, expected)
+}
+
+func TestMget(t *testing.T) {
+	exchanges := [][2]string{
+		{resp("MGET", "key1", "key2", "key3"), "*3\r\n$5\r\nhello\r\n$-1\r\n$0\r\n\r\n"},
+	}
+	expected := []model.Event{
+		{Type: model.EventGetHit, Key: "key1", Size: 5},
+		{Type: model.EventGetMiss, Key: "key2"},
+		{Type: model.EventGetHit, Key: "key3", Size: 0},
+	}
+	testExchanges(t, exchanges, expected)
+}
+
+func TestPipeline(t *testing.T) {
+	input := []string{
+		"*2", "$3", "GET", "$4", "key1",
+		"*3", "$3", "SET", "$4", "key2", "$3", "abc",
+		"*2", "$4", "PING", "$3", "123",
+		"*3", "$4", "MGET", "$4", "key3", "$4", "key4",
+	}
+	output := []string{
+		"$-1",
+		"+OK",
+		"$3", "123",
+		"*2", "$2", "hi", "$-1",
+	}
+	expected := []model.Event{
+		{Type: model.EventGetMiss, Key: "key1"},
+		{Type: model.EventSet, Key: "key2", Size: 3, Outcome: model.OutcomeStored},
+		{Type: model.EventGetHit, Key: "key3", Size: 2},
+		{Type: model.EventGetMiss, Key: "key4"},
+	}
+	test(t, input, output, expected)
+}
+
+func TestDataStructures(t *testing.T) {
+	exchanges := [][2]string{
+		{resp("HGET", "hash1", "field"), "$5\r\nhello\r\n"},
+		{resp("HGETALL", "hash2"), "*4\r\n$2\r\nf1\r\n$3\r\nabc\r\n$2\r\nf2\r\n$4\r\nwxyz\r\n"},
+		{resp("ZRANGE", "zset1", "0", "-1", "WITHSCORES"), "*2\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$2\r\nbb\r\n$1\r\n2\r\n"},
+		{resp("HSET", "hash3", "field", "value"), ":1\r\n"},
+		{resp("EXISTS", "key1", "key2"), ":1\r\n"},
+		{resp("MSET", "key3", "abc", "key4", "de"), "+OK\r\n"},
+		{resp("LPUSH", "list1", "x"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	}
+	expected := []model.Event{
+		{Type: model.EventRead, Key: "hash1", Size: 5},
+		{Type: model.EventRead, Key: "hash2", Size: 11},
+		{Type: model.EventRead, Key: "zset1", Size: 5},
+		{Type: model.EventWrite, Key: "hash3", Size: 10},
+		{Type: model.EventRead, Key: "key1"},
+		{Type: model.EventRead, Key: "key2"},
+		{Type: model.EventSet, Key: "key3", Size: 3, Outcome: model.OutcomeStored},
+		{Type: model.EventSet, Key: "key4", Size: 2, Outcome: model.OutcomeStored},
+		{Type: model.EventError, Key: "list1", Error: "WRONGTYPE", Command: "write"},
+	}
+	testExchanges(t, exchanges, expected)
+}
+
+func TestResp3Push(t *testing.T) {
+	exchanges := [][2]string{
+		{resp("HELLO", "3"), "%1\r\n+proto\r\n:3\r\n"},
+		{resp("CLIENT", "TRACKING", "on"), "+OK\r\n"},
+		{resp("GET", "key1"), ">2\r\n$10\r\ninvalidate\r\n*1\r\n$4\r\nkey2\r\n$5\r\nhello\r\n"},
+		{"", ">2\r\n$10\r\ninvalidate\r\n*1\r\n$4\r\nkey1\r\n"},
+		{resp("GET", "key3"), "_\r\n"},
+	}
+	expected := []model.Event{
+		{Type: model.EventGetHit, Key: "key1", Size: 5},
+		{Type: model.EventGetMiss, Key: "key3"},
+	}
+	testExchanges(t, exchanges, expected)
+}
+
+func TestInline(t *testing.T) {
+	exchanges := [][2]string{
+		{"PING\r\n", "+PONG\r\n"},
+		{"\r\n", ""},
+		{"GET  key1\r\n", "$5\r\nhello\r\n"},
+		{resp("GET", "key2"), "$-1\r\n"},
+		{"SET key3 abc\n", "+OK\r\n"},
+	}
+	expected := []model.Event{
+		{Type: model.EventGetHit, Key: "key1", Size: 5},
+		{Type: model.EventGetMiss, Key: "key2"},
+		{Type: model.EventSet, Key: "key3", Size: 3, Outcome: model.OutcomeStored},
+	}
+	testExchanges(t, exchanges, expected)
+}
+
+func TestSession(t *testing.T) {
+	exchanges := [][2]string{
+		{resp("GET", "key1"), "$-1\r\n"},
+		{resp("SELECT", "3") + resp("GET", "key1"), "+OK\r\n$-1\r\n"},
+		{resp("AUTH", "alice", "secret"), "+OK\r\n"},
+		{resp("GET", "key2"), "$-1\r\n"},
+		{resp("SELECT", "99"), "-ERR DB index is out of range\r\n"},
+		{resp("AUTH", "secret"), "+OK\r\n"},
+		{resp("HELLO", "2", "AUTH", "bob", "secret"), "*0\r\n"},
+		{resp("GET", "key3"), "$-1\r\n"},
+	}
+	expected := []model.Event{
+		{Type: model.EventGetMiss, Key: "key1"},
+		{Type: model.EventGetMiss, Key: "key1", DB: 3},
+		{Type: model.EventGetMiss, Key: "key2", DB: 3, User: "alice"},
+		{Type: model.EventError, DB: 3, User: "alice", Error: "ERR"},
+		{Type: model.EventGetMiss, Key: "key3", DB: 3, User: "bob"},
+	}
+	testExchanges(t, exchanges, expected)
+}
+
+func TestTransaction(t *testing.T) {
+	exchanges := [][2]string{
+		{resp("MULTI"), "+OK\r\n"},
+		{resp("SET", "key1", "abc"), "+QUEUED\r\n"},
+		{resp("GET", "key2"), "+QUEUED\r\n"},
+		{resp("HGETALL", "hash1"), "+QUEUED\r\n"},
+		{resp("EXEC"), "*3\r\n+OK\r\n$5\r\nhello\r\n*2\r\n$1\r\nf\r\n$2\r\nvv\r\n"},
+		{resp("MULTI") + resp("SET", "key3", "x") + resp("DISCARD"), "+OK\r\n+QUEUED\r\n+OK\r\n"},
+		{resp("WATCH", "key4"), "+OK\r\n"},
+		{resp("MULTI") + resp("GET", "key4") + resp("EXEC"), "+OK\r\n+QUEUED\r\n*-1\r\n"},
+		{resp("GET", "key5"), "$-1\r\n"},
+	}
+	expected := []model.Event{
+		{Type: model.EventSet, Key: "key1", Size: 3, Outcome: model.OutcomeStored},
+		{Type: model.EventGetHit, Key: "key2", Size: 5},
+		{Type: model.EventRead, Key: "hash1", Size: 3},
+		{Type: model.EventGetMiss, Key: "key5"},
+	}
+	testExchanges(t, exchanges, expected)
+}
+
+func TestScripts(t *testing.T) {
+	exchanges := [][2]string{
+		{resp("EVAL", "return 1", "0"), ":1\r\n"},
+		{resp("EVALSHA", "E0E1F9FABFC9D4800C877A703B823AC0578FF8DB", "2", "key1", "key2", "arg"), "$4\r\nabcd\r\n"},
+		{resp("FCALL", "myfunc", "1", "key3"), "-ERR Function not found\r\n"},
+	}
+	expected := []model.Event{
+		{Type: model.EventScript, Script: "e0e1f9fabfc9d4800c877a703b823ac0578ff8db"},
+		{Type: model.EventScript, Key: "key1", Size: 2, Script: "e0e1f9fabfc9d4800c877a703b823ac0578ff8db"},
+		{Type: model.EventScript, Key: "key2", Size: 2, Script: "e0e1f9fabfc9d4800c877a703b823ac0578ff8db"},
+		{Type: model.EventError, Key: "key3", Script: "myfunc", Error: "ERR", Command: "script"},
+	}
+	testExchanges(t, exchanges, expected)
+}
+
+func TestPubSub(t *testing.T) {
+	exchanges := [][2]string{
+		{resp("PUBLISH", "chan1", "hello"), ":2\r\n"},
+		{resp("SUBSCRIBE", "chan1", "chan2"), subscription("subscribe", "chan1", 1) + subscription("subscribe", "chan2", 2)},
+		{"", resp("message", "chan1", "hello")},
+		{resp("PSUBSCRIBE", "ch*"), subscription("psubscribe", "ch*", 3)},
+		{"", resp("pmessage", "ch*", "chan3", "abc")},
+		{resp("PING"), resp("pong", "")},
+		{resp("UNSUBSCRIBE"), subscription("unsubscribe", "chan1", 2) + subscription("unsubscribe", "chan2", 1)},
+		{resp("PUNSUBSCRIBE"), subscription("punsubscribe", "ch*", 0)},
+		{resp("GET", "key1"), "$-1\r\n"},
+	}
+	expected := []model.Event{
+		{Type: model.EventPublish, Key: "chan1", Size: 5},
+		{Type: model.EventMessage, Key: "chan1", Size: 5},
+		{Type: model.EventMessage, Key: "chan3", Size: 3},
+		{Type: model.EventGetMiss, Key: "key1"},
+	}
+	testExchanges(t, exchanges, expected)
+}
+
+func TestMonitor(t *testing.T) {
+	exchanges := [][2]string{
+		{resp("GET", "key1"), "$-1\r\n"},
+		{resp("MONITOR"), "+OK\r\n"},
+		{"", "+1339518083.107412 [0 127.0.0.1:60866] \"keys\" \"*\"\r\n"},
+		{resp("GET", "key2"), "+1339518087.877697 [0 127.0.0.1:60866] \"get\" \"key2\"\r\n"},
+	}
+	expected := []model.Event{
+		{Type: model.EventGetMiss, Key: "key1"},
+	}
+	testExchanges(t, exchanges, expected)
+}
+
+func TestRedirect(t *testing.T) {
+	exchanges := [][2]string{
+		{resp("GET", "key1"), "-MOVED 9189 10.0.0.2:6379\r\n"},
+		{resp("SET", "key2", "abc"), "-ASK 4998 10.0.0.3:6379\r\n"},
+		{resp("GET", "key3"), "-ERR unknown\r\n"},
+	}
+	expected := []model.Event{
+		{Type: model.EventRedirect, Key: "key1", Outcome: model.OutcomeMoved, Target: "10.0.0.2:6379"},
+		{Type: model.EventRedirect, Key: "key2", Outcome: model.OutcomeAsk, Target: "10.0.0.3:6379"},
+		{Type: model.EventError, Key: "key3", Error: "ERR", Command: "get"},
+	}
+	testExchanges(t, exchanges, expected)
+}
+
+func TestErrors(t *testing.T) {
+	exchanges := [][2]string{
+		{resp("GET", "key1"), "-NOAUTH Authentication required.\r\n"},
+		{resp("MULTI"), "+OK\r\n"},
+		{resp("INCR", "key2"), "+QUEUED\r\n"},
+		{resp("EXEC"), "*1\r\n-ERR value is not an integer or out of range\r\n"},
+		{resp("PING"), "-OOM command not allowed\r\n"},
+	}
+	expected := []model.Event{
+		{Type: model.EventError, Key: "key1", Error: "NOAUTH", Command: "get"},
+		{Type: model.EventError, Key: "key2", Error: "ERR", Command: "incr"},
+		{Type: model.EventError, Error: "OOM"},
+	}
+	testExchanges(t, exchanges, expected)
+}
+
+func TestLatencySplitValue(t *testing.T) {
+	var evts []model.Event
+	c := model.New(func(e []model.Event) { evts = append(evts, e...) }, NewFsm(log.ConsoleLogger{}))
+	start := time.Unix(100, 0)
+	value := strings.Repeat("x", 3000)
+	c.ClientStream().Reassembled([]tcpassembly.Reassembly{{Bytes: []byte(resp("get", "key1")), Seen: start}})
+	c.ServerStream().Reassembled([]tcpassembly.Reassembly{{
+		Bytes: []byte("$3000\r\n" + value[:1000]),
+		Seen:  start.Add(time.Millisecond),
+	}})
+	c.ServerStream().Reassembled([]tcpassembly.Reassembly{{
+		Bytes: []byte(value[1000:] + "\r\n"),
+		Seen:  start.Add(51 * time.Millisecond),
+	}})
+	c.ClientStream().ReassemblyComplete()
+	c.ServerStream().ReassemblyComplete()
+
+	if len(evts) != 1 || evts[0].Latency != 51*time.Millisecond {
+		t.Error(evts)
+	}
+}
+
 func resp(fields ...string) string {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "*%d\r\n", len(fields))
@@ -73,6 +330,11 @@ func resp(fields ...string) string {
 	return buf.String()
 }
 
+// subscription builds the message confirming a change to a subscription.
+func subscription(kind string, channel string, count int) string {
+	return fmt.Sprintf("*3\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n:%d\r\n", len(kind), kind, len(channel), channel, count)
+}
+
 func reassemblyString(s string) []tcpassembly.Reassembly {
 	return []tcpassembly.Reassembly{{Bytes: []byte(s)}}
 }
@@ -103,3 +365,31 @@ func test(t *testing.T, input []string, output []string, expected []model.Event)
 		t.Error("Expected", expected, "events but never received")
 	}
 }
+
+// testExchanges sends each client request followed by its server response.
+func testExchanges(t *testing.T, exchanges [][2]string, expected []model.Event) {
+	handler := func(evts []model.Event) {
+		for _, e := range evts {
+			if len(expected) == 0 {
+				t.Error("Unexpected event", e)
+				continue
+			}
+			if e != expected[0] {
+				t.Error("Expected", expected[0], "got", e)
+			}
+			expected = expected[1:]
+		}
+	}
+
+	c := model.New(handler, NewFsm(log.ConsoleLogger{}))
+	for _, ex := range exchanges {
+		c.ClientStream().Reassembled(reassemblyString(ex[0]))
+		c.ServerStream().Reassembled(reassemblyString(ex[1]))
+	}
+	c.ClientStream().ReassemblyComplete()
+	c.ServerStream().ReassemblyComplete()
+
+	if len(expected) > 0 {
+		t.Error("Expected", expected, "events but never received")
+	}
+}
@@ -0,0 +1,117 @@
+package redis
+
+import (
+	"strings"
+
+	"github.com/box/memsniff/assembly/reader"
+	"github.com/box/memsniff/protocol/model"
+)
+
+// parsePubSub handles commands that publish messages or change the
+// connection into or out of a subscriber or monitor.
+func (f *fsm) parsePubSub(cmd command, name string, fields [][]byte, args []interface{}) command {
+	switch name {
+	case "publish", "spublish":
+		if len(fields) < 3 {
+			return cmd
+		}
+		cmd.handleReply = f.handleWrite
+		cmd.evtType = model.EventPublish
+		cmd.keys = []string{string(fields[1])}
+		cmd.sizes = []int{bulkSize(args[2])}
+	case "subscribe", "psubscribe", "ssubscribe":
+		// confirmed by one message per channel rather than a single reply
+		cmd.handleReply = nil
+		f.setSubscribed(true)
+	case "unsubscribe", "punsubscribe", "sunsubscribe":
+		// confirmed by one message per channel, or a single message if not
+		// subscribed to any
+		cmd.handleReply = nil
+	case "monitor":
+		f.monitor = true
+	}
+	return cmd
+}
+
+// setSubscribed records whether the connection is in subscriber mode.
+// Channel names are only captured in subscriber mode, since most other
+// replies contain values that are too large to be worth copying.
+func (f *fsm) setSubscribed(subscribed bool) {
+	f.subscribed = subscribed
+	if subscribed {
+		f.serverParser.Options.BulkCaptureLimit = maxCommandSize
+	} else {
+		f.serverParser.Options.BulkCaptureLimit = 0
+	}
+}
+
+// isPubSubMessage returns true if res is a message sent to a RESP2
+// subscriber, rather than a reply to a command.
+func (f *fsm) isPubSubMessage(res interface{}) bool {
+	if !f.subscribed {
+		return false
+	}
+	values, ok := res.([]interface{})
+	if !ok || len(values) < 3 {
+		return false
+	}
+	switch pushKind(values) {
+	case "message", "pmessage", "smessage",
+		"subscribe", "psubscribe", "ssubscribe",
+		"unsubscribe", "punsubscribe", "sunsubscribe":
+		return true
+	default:
+		return false
+	}
+}
+
+// pushKind returns the type of a push message, such as "message" or
+// "invalidate", or the empty string if it was not captured.
+func pushKind(values []interface{}) string {
+	if len(values) == 0 {
+		return ""
+	}
+	kind, _ := values[0].([]byte)
+	return strings.ToLower(string(kind))
+}
+
+// handlePush processes a message sent by the server outside of the
+// request/response cycle.
+func (f *fsm) handlePush(values []interface{}) {
+	switch pushKind(values) {
+	case "message", "smessage":
+		if len(values) >= 3 {
+			f.addMessage(values[1], values[2])
+		}
+	case "pmessage":
+		// the matching pattern precedes the channel
+		if len(values) >= 4 {
+			f.addMessage(values[2], values[3])
+		}
+	case "unsubscribe", "punsubscribe", "sunsubscribe":
+		// includes the number of subscriptions remaining
+		if len(values) >= 3 {
+			if n, ok := values[2].(int); ok && n == 0 {
+				f.setSubscribed(false)
+			}
+		}
+	}
+}
+
+// addMessage records a message delivered to a subscriber.
+func (f *fsm) addMessage(channel interface{}, payload interface{}) {
+	ch, _ := channel.([]byte)
+	f.addEvent(command{db: f.db, user: f.user}, model.Event{
+		Type: model.EventMessage,
+		Key:  string(ch),
+		Size: bulkSize(payload),
+	})
+}
+
+// ignore discards all data on a connection that no longer follows the
+// request/response protocol, such as one in MONITOR mode.
+func (f *fsm) ignore() error {
+	f.consumer.ClientReader.Truncate()
+	f.consumer.ServerReader.Truncate()
+	return reader.ErrShortRead
+}
@@ -1,7 +1,9 @@
 package redis
 
 import (
+	"bytes"
 	"errors"
+	"math/big"
 	"strconv"
 
 	"github.com/box/memsniff/assembly/reader"
@@ -14,7 +16,21 @@ const (
 	tagBulk   = '$'
 	tagArray  = '*'
 
+	// RESP3 types
+	tagNull           = '_'
+	tagDouble         = ','
+	tagBoolean        = '#'
+	tagBlobError      = '!'
+	tagVerbatimString = '='
+	tagBigNumber      = '('
+	tagMap            = '%'
+	tagSet            = '~'
+	tagAttribute      = '|'
+	tagPush           = '>'
+
 	stackLimit = 8
+	// blobErrorCaptureLimit is the largest blob error whose message is kept.
+	blobErrorCaptureLimit = 1024
 )
 
 var (
@@ -22,8 +38,28 @@ var (
 	RecursionLimitErr = errors.New("too many nested RESP arrays")
 )
 
+// Push is an out-of-band RESP3 push message, such as a pub/sub message or a
+// client-side caching invalidation, which is not a reply to any command.
+type Push []interface{}
+
 type ParserOptions struct {
 	BulkCaptureLimit int
+	// InlineCommands allows a top-level value to be an inline command: a
+	// line of space-separated arguments, as sent by telnet or health checks.
+	// Inline commands are returned as arrays of []byte.
+	InlineCommands bool
+}
+
+// IsReplyTag returns true if b is the first byte of a valid RESP reply.
+func IsReplyTag(b byte) bool {
+	switch b {
+	case tagStatus, tagError, tagInt, tagBulk, tagArray,
+		tagNull, tagDouble, tagBoolean, tagBlobError, tagVerbatimString,
+		tagBigNumber, tagMap, tagSet, tagAttribute, tagPush:
+		return true
+	default:
+		return false
+	}
 }
 
 // RespParser implements a stack machine to support RESP's potentially infinite
@@ -42,6 +78,11 @@ type ParserOptions struct {
 type RespParser struct {
 	stack   []stackFrame
 	Options ParserOptions
+	// size is the total length of string data parsed so far.
+	size int
+	// elementEnds holds the value of size at the end of each element of a
+	// top-level array.
+	elementEnds []int
 }
 
 // stackFrame holds a resumable piece of execution state.
@@ -64,6 +105,8 @@ func NewParser(r *reader.Reader) *RespParser {
 // single RESP value from r.
 func (p *RespParser) Reset(r *reader.Reader) {
 	p.stack = p.stack[:1]
+	p.size = 0
+	p.elementEnds = p.elementEnds[:0]
 	p.startParseValue(r)
 }
 
@@ -83,6 +126,24 @@ func (p *RespParser) Result() interface{} {
 	return p.stack[len(p.stack)-1].result
 }
 
+// Size returns the total length of the bulk and simple strings in the parsed
+// value, including the elements of nested arrays.  Bulk strings larger than
+// BulkCaptureLimit are counted even though their contents are discarded.
+func (p *RespParser) Size() int {
+	return p.size
+}
+
+// ElementSizes returns the Size of each element of a top-level array.
+func (p *RespParser) ElementSizes() []int {
+	sizes := make([]int, len(p.elementEnds))
+	prev := 0
+	for i, end := range p.elementEnds {
+		sizes[i] = end - prev
+		prev = end
+	}
+	return sizes
+}
+
 func (p *RespParser) BulkArray() [][]byte {
 	res := p.Result().([]interface{})
 	out := make([][]byte, len(res))
@@ -123,9 +184,32 @@ func (p *RespParser) startParseValue(r *reader.Reader) {
 			p.startParseInt(r)
 		case tagBulk:
 			p.startParseBulk(r)
-		case tagArray:
-			p.startParseArray(r)
+		case tagArray, tagSet:
+			p.startParseArray(r, 1)
+		case tagMap:
+			p.startParseArray(r, 2)
+		case tagNull:
+			p.startParseNull(r)
+		case tagDouble:
+			p.startParseDouble(r)
+		case tagBoolean:
+			p.startParseBoolean(r)
+		case tagBigNumber:
+			p.startParseBigNumber(r)
+		case tagVerbatimString:
+			// the format prefix, e.g. "txt:", is counted as part of the string
+			p.startParseBulk(r)
+		case tagBlobError:
+			p.startParseBlobError(r)
+		case tagAttribute:
+			p.startParseAttribute(r)
+		case tagPush:
+			p.startParsePush(r)
 		default:
+			if p.Options.InlineCommands && len(p.stack) == 1 {
+				p.startParseInline(r, out[0])
+				return nil
+			}
 			return ProtocolErr
 		}
 		return nil
@@ -141,6 +225,7 @@ func (p *RespParser) startParseSimpleString(r *reader.Reader, asError bool) {
 		if asError {
 			p.pop(errors.New(string(out)))
 		} else {
+			p.size += len(out)
 			p.pop(string(out))
 		}
 		return nil
@@ -171,6 +256,7 @@ func (p *RespParser) startParseBulk(r *reader.Reader) {
 			p.pop(nil)
 			return nil
 		}
+		p.size += result
 		if result <= p.Options.BulkCaptureLimit {
 			p.pop(nil)
 			p.startParseBulkN(r, make([]byte, 0, result), result)
@@ -201,12 +287,154 @@ func (p *RespParser) startParseBulkN(r *reader.Reader, accum []byte, n int) {
 	})
 }
 
-func (p *RespParser) startParseArray(r *reader.Reader) {
+// startParseArray parses an aggregate type with elementsPerEntry values for
+// each entry in its length, e.g. 2 for a map.  Maps and sets are returned as
+// arrays, with keys and values alternating for a map.
+func (p *RespParser) startParseArray(r *reader.Reader, elementsPerEntry int) {
 	p.push(func() error {
 		n := p.Result().(int)
 		p.pop(nil)
+		if n < 0 {
+			// RESP2 nil array
+			return nil
+		}
+		n *= elementsPerEntry
 		p.stack[len(p.stack)-1].result = make([]interface{}, 0, n)
-		p.startParseNArrayFields(r, n)
+		if n > 0 {
+			p.startParseNArrayFields(r, n)
+		}
+		return nil
+	})
+	p.startParseInt(r)
+}
+
+// startParseInline parses the rest of an inline command beginning with first.
+// Quoted arguments are not supported.
+func (p *RespParser) startParseInline(r *reader.Reader, first byte) {
+	if first == '\n' {
+		// empty line
+		p.push(func() error {
+			p.pop([]interface{}{})
+			return nil
+		})
+		return
+	}
+	p.push(func() error {
+		out, err := r.ReadLine()
+		if err != nil {
+			return err
+		}
+		line := append([]byte{first}, out...)
+		fields := bytes.Fields(line)
+		args := make([]interface{}, len(fields))
+		for i, f := range fields {
+			args[i] = f
+			p.size += len(f)
+		}
+		p.pop(args)
+		return nil
+	})
+}
+
+// startParsePush parses a push message, which has the same format as an array.
+func (p *RespParser) startParsePush(r *reader.Reader) {
+	p.push(func() error {
+		res, _ := p.Result().([]interface{})
+		p.pop(Push(res))
+		return nil
+	})
+	p.startParseArray(r, 1)
+}
+
+// startParseAttribute parses and discards an attribute map, followed by the
+// value it describes.
+func (p *RespParser) startParseAttribute(r *reader.Reader) {
+	p.push(func() error {
+		p.pop(nil)
+		p.startParseValue(r)
+		return nil
+	})
+	p.startParseArray(r, 2)
+}
+
+func (p *RespParser) startParseNull(r *reader.Reader) {
+	p.push(func() error {
+		_, err := r.ReadLine()
+		if err != nil {
+			return err
+		}
+		p.pop(nil)
+		return nil
+	})
+}
+
+func (p *RespParser) startParseDouble(r *reader.Reader) {
+	p.push(func() error {
+		out, err := r.ReadLine()
+		if err != nil {
+			return err
+		}
+		f, err := strconv.ParseFloat(string(out), 64)
+		if err != nil {
+			return err
+		}
+		p.pop(f)
+		return nil
+	})
+}
+
+func (p *RespParser) startParseBoolean(r *reader.Reader) {
+	p.push(func() error {
+		out, err := r.ReadLine()
+		if err != nil {
+			return err
+		}
+		switch string(out) {
+		case "t":
+			p.pop(true)
+		case "f":
+			p.pop(false)
+		default:
+			return ProtocolErr
+		}
+		return nil
+	})
+}
+
+func (p *RespParser) startParseBigNumber(r *reader.Reader) {
+	p.push(func() error {
+		out, err := r.ReadLine()
+		if err != nil {
+			return err
+		}
+		n, ok := new(big.Int).SetString(string(out), 10)
+		if !ok {
+			return ProtocolErr
+		}
+		p.pop(n)
+		return nil
+	})
+}
+
+// startParseBlobError parses a length-prefixed error, keeping its message if
+// it is no larger than blobErrorCaptureLimit.
+func (p *RespParser) startParseBlobError(r *reader.Reader) {
+	p.push(func() error {
+		n := p.Result().(int)
+		p.pop(nil)
+		if n < 0 {
+			return ProtocolErr
+		}
+		if n > blobErrorCaptureLimit {
+			r.Discard(n + 2)
+			p.stack[len(p.stack)-1].result = errors.New("blob error")
+			return nil
+		}
+		p.push(func() error {
+			p.pop(errors.New(string(p.Result().([]byte))))
+			return nil
+		})
+		p.startParseBulkN(r, make([]byte, 0, n), n)
 		return nil
 	})
 	p.startParseInt(r)
@@ -215,6 +443,9 @@ func (p *RespParser) startParseArray(r *reader.Reader) {
 func (p *RespParser) startParseNArrayFields(r *reader.Reader, n int) {
 	p.push(func() error {
 		// value parsed
+		if len(p.stack) == 2 {
+			p.elementEnds = append(p.elementEnds, p.size)
+		}
 		result := p.Result()
 		results := append(p.stack[len(p.stack)-2].result.([]interface{}), result)
 		p.pop(results)
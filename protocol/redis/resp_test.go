@@ -2,6 +2,7 @@ package redis
 
 import (
 	"bytes"
+	"fmt"
 	"reflect"
 	"regexp"
 	"testing"
@@ -160,6 +161,78 @@ func TestCaptureLargeBulk(t *testing.T) {
 	}
 }
 
+func TestSize(t *testing.T) {
+	r := reader.New()
+	write(r, `*3
+		$5
+		hello
+		*2
+		+OK
+		:123
+		$-1
+		`)
+	p := NewParser(r)
+	err := p.Run()
+	if err != nil {
+		t.Error(err)
+	}
+	if p.Size() != 7 {
+		t.Error(p.Size(), 7)
+	}
+	if sizes := p.ElementSizes(); !reflect.DeepEqual(sizes, []int{5, 2, 0}) {
+		t.Error(sizes, []int{5, 2, 0})
+	}
+	p.Reset(r)
+	if p.Size() != 0 {
+		t.Error(p.Size(), 0)
+	}
+}
+
+func TestResp3(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"_\n", nil},
+		{",3.5\n", 3.5},
+		{"#t\n", true},
+		{"#f\n", false},
+		{"(12345678901234567890\n", "12345678901234567890"},
+		{"=8\ntxt:abcd\n", []byte("txt:abcd")},
+		{"!9\nERR oops!\n", "ERR oops!"},
+		{"%2\n+a\n:1\n+b\n:2\n", []interface{}{"a", 1, "b", 2}},
+		{"~2\n+a\n+b\n", []interface{}{"a", "b"}},
+		{"|1\n+ttl\n:3600\n$2\nhi\n", []byte("hi")},
+		{">2\n$10\ninvalidate\n*1\n$4\nkey1\n", Push{[]byte("invalidate"), []interface{}{[]byte("key1")}}},
+		{"*0\n", []interface{}{}},
+		{"*-1\n", nil},
+	}
+	for _, tt := range tests {
+		r := reader.New()
+		write(r, tt.input)
+		p := NewParser(r)
+		p.Options.BulkCaptureLimit = 1024
+		err := p.Run()
+		if err != nil {
+			t.Error(tt.input, err)
+			continue
+		}
+		res := p.Result()
+		switch v := res.(type) {
+		case error:
+			res = v.Error()
+		case fmt.Stringer:
+			res = v.String()
+		}
+		if !reflect.DeepEqual(res, tt.expected) {
+			t.Errorf("%q: expected %#v, got %#v", tt.input, tt.expected, res)
+		}
+		if _, err := r.PeekN(1); err == nil {
+			t.Errorf("%q: data left unread", tt.input)
+		}
+	}
+}
+
 func TestStackLimit(t *testing.T) {
 	r := reader.New()
 	p := NewParser(r)
@@ -0,0 +1,48 @@
+package redis
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/box/memsniff/protocol/model"
+)
+
+// parseScript handles EVAL, EVALSHA and FCALL and their read-only variants,
+// which take a script or function, the number of keys, the keys and then
+// any other arguments.
+func (f *fsm) parseScript(cmd command, name string, fields [][]byte, args []interface{}) command {
+	if len(fields) < 3 {
+		return cmd
+	}
+	numKeys, err := strconv.Atoi(string(fields[2]))
+	if err != nil || numKeys < 0 || 3+numKeys > len(fields) {
+		return cmd
+	}
+	switch name {
+	case "eval", "eval_ro":
+		// identify the script by the same SHA1 digest used by EVALSHA, if
+		// it was small enough to capture
+		if fields[1] != nil {
+			sum := sha1.Sum(fields[1])
+			cmd.script = hex.EncodeToString(sum[:])
+		}
+	case "evalsha", "evalsha_ro":
+		cmd.script = strings.ToLower(string(fields[1]))
+	default:
+		cmd.script = string(fields[1])
+	}
+
+	cmd.handleReply = f.handleRead
+	cmd.evtType = model.EventScript
+	cmd.keys = make([]string, numKeys)
+	for i := range cmd.keys {
+		cmd.keys[i] = string(fields[3+i])
+	}
+	if numKeys == 0 {
+		// still record the invocation
+		cmd.keys = []string{""}
+	}
+	return cmd
+}
@@ -0,0 +1,49 @@
+package redis
+
+// parseTransaction handles MULTI, EXEC and DISCARD.
+func (f *fsm) parseTransaction(cmd command, name string) command {
+	switch name {
+	case "multi":
+		f.inMulti = true
+		f.queued = f.queued[:0]
+	case "exec":
+		f.inMulti = false
+		cmd.handleReply = f.handleExec
+	case "discard":
+		f.inMulti = false
+		cmd.handleReply = f.handleDiscard
+	}
+	return cmd
+}
+
+// handleQueued records a command that the server accepted into the current
+// transaction.
+func (f *fsm) handleQueued(cmd command, res interface{}, size int) {
+	if res == "QUEUED" {
+		f.queued = append(f.queued, cmd)
+	}
+}
+
+// handleExec attributes each element of the EXEC reply to the corresponding
+// queued command.  A nil reply means the transaction was aborted because a
+// WATCHed key changed, and an error means it was never run.
+func (f *fsm) handleExec(cmd command, res interface{}, size int) {
+	queued := f.queued
+	f.queued = f.queued[:0]
+	results, ok := res.([]interface{})
+	if !ok || len(results) != len(queued) {
+		return
+	}
+	sizes := f.serverParser.ElementSizes()
+	for i, q := range queued {
+		if q.execReply != nil {
+			// commands such as UNSUBSCRIBE have no reply to handle
+			q.execReply(q, results[i], sizes[i])
+		}
+		f.handleError(q, results[i])
+	}
+}
+
+func (f *fsm) handleDiscard(cmd command, res interface{}, size int) {
+	f.queued = f.queued[:0]
+}
@@ -0,0 +1,22 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/box/memsniff/protocol/model"
+)
+
+func TestExecUnsubscribe(t *testing.T) {
+	exchanges := [][2]string{
+		{resp("MULTI"), "+OK\r\n"},
+		{resp("UNSUBSCRIBE"), "+QUEUED\r\n"},
+		{resp("GET", "key1"), "+QUEUED\r\n"},
+		{resp("EXEC"), "*2\r\n" + "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n" + "$2\r\nhi\r\n"},
+		{resp("GET", "key2"), "$-1\r\n"},
+	}
+	expected := []model.Event{
+		{Type: model.EventGetHit, Key: "key1", Size: 2},
+		{Type: model.EventGetMiss, Key: "key2"},
+	}
+	testExchanges(t, exchanges, expected)
+}